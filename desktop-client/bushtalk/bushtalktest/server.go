@@ -0,0 +1,392 @@
+// Package bushtalktest provides a local fake of the Bushtalk Radio API for
+// tests and offline development.
+package bushtalktest
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bushtalkradio/xplane-client/bushtalk"
+)
+
+// Server fakes /api/authenticate, /api/refresh, /api/track and
+// /api/track/batch. It checks the X-BTR-CLIENT-* headers and bearer
+// tokens, records received positions, and can inject error responses
+// and latency. Server is an http.Handler; NewServer also starts it.
+type Server struct {
+	// URL is set by NewServer to the base URL of the running server
+	URL string
+
+	srv *httptest.Server
+	mux *http.ServeMux
+
+	mu            sync.Mutex
+	users         map[string]string // username -> password
+	idTokens      map[string]string // ID token -> username
+	refreshTokens map[string]string // refresh token -> username
+	tokenTTL      time.Duration
+	positions     []bushtalk.TrackPayload
+	requests      int
+	failStatus    int
+	failCount     int
+	retryAfter    time.Duration
+	latency       time.Duration
+	noBatch       bool
+	refreshStatus int
+	reject        func(bushtalk.TrackPayload) bool
+}
+
+// New creates a fake API handler with no users
+func New() *Server {
+	s := &Server{
+		mux:           http.NewServeMux(),
+		users:         make(map[string]string),
+		idTokens:      make(map[string]string),
+		refreshTokens: make(map[string]string),
+		tokenTTL:      time.Hour,
+	}
+	s.mux.HandleFunc("/api/authenticate", s.handleAuthenticate)
+	s.mux.HandleFunc("/api/refresh", s.handleRefresh)
+	s.mux.HandleFunc("/api/track", s.handleTrack)
+	s.mux.HandleFunc("/api/track/batch", s.handleTrackBatch)
+	return s
+}
+
+// NewServer starts a fake API on a random local port; pass s.URL to bushtalk.NewClient
+func NewServer() *Server {
+	s := New()
+	s.srv = httptest.NewServer(s)
+	s.URL = s.srv.URL
+	return s
+}
+
+// Close shuts down a server started with NewServer
+func (s *Server) Close() {
+	if s.srv != nil {
+		s.srv.Close()
+	}
+}
+
+// ServeHTTP applies injected latency and failures, then dispatches the request
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests++
+	latency := s.latency
+	status := 0
+	retryAfter := s.retryAfter
+	if s.failCount > 0 && strings.HasPrefix(r.URL.Path, "/api/") {
+		s.failCount--
+		status = s.failStatus
+	}
+	s.mu.Unlock()
+
+	if latency > 0 {
+		// Read the body first so the server notices if the client hangs up
+		body, _ := io.ReadAll(r.Body)
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		select {
+		case <-time.After(latency):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	if status != 0 {
+		if retryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())))
+		}
+		writeError(w, status, "injected failure")
+		return
+	}
+
+	s.mux.ServeHTTP(w, r)
+}
+
+// AddUser registers a username and password that can log in
+func (s *Server) AddUser(username, password string) {
+	s.mu.Lock()
+	s.users[username] = password
+	s.mu.Unlock()
+}
+
+// SetTokenTTL sets the expires_in returned with new ID tokens
+func (s *Server) SetTokenTTL(ttl time.Duration) {
+	s.mu.Lock()
+	s.tokenTTL = ttl
+	s.mu.Unlock()
+}
+
+// IssueToken returns a valid ID token for username without going through /api/authenticate
+func (s *Server) IssueToken(username string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	token := newToken()
+	s.idTokens[token] = username
+	return token
+}
+
+// ExpireTokens invalidates all ID tokens, as if they had expired. Refresh tokens stay valid.
+func (s *Server) ExpireTokens() {
+	s.mu.Lock()
+	s.idTokens = make(map[string]string)
+	s.mu.Unlock()
+}
+
+// RevokeSessions invalidates all ID and refresh tokens, forcing a new login
+func (s *Server) RevokeSessions() {
+	s.mu.Lock()
+	s.idTokens = make(map[string]string)
+	s.refreshTokens = make(map[string]string)
+	s.mu.Unlock()
+}
+
+// FailNext makes the next count API requests fail with status.
+// A non-zero retryAfter is sent as the Retry-After header.
+func (s *Server) FailNext(status, count int, retryAfter time.Duration) {
+	s.mu.Lock()
+	s.failStatus = status
+	s.failCount = count
+	s.retryAfter = retryAfter
+	s.mu.Unlock()
+}
+
+// SetLatency delays every response by d
+func (s *Server) SetLatency(d time.Duration) {
+	s.mu.Lock()
+	s.latency = d
+	s.mu.Unlock()
+}
+
+// DisableBatch makes the batch endpoint respond 404, like an older server
+func (s *Server) DisableBatch(disable bool) {
+	s.mu.Lock()
+	s.noBatch = disable
+	s.mu.Unlock()
+}
+
+// FailRefresh makes the refresh endpoint respond with status, for example
+// 400 for a refresh token the server won't accept. Pass 0 to serve it again.
+func (s *Server) FailRefresh(status int) {
+	s.mu.Lock()
+	s.refreshStatus = status
+	s.mu.Unlock()
+}
+
+// RejectPositions makes the track endpoints respond 422 to positions for
+// which reject returns true. A batch holding any of them is rejected whole.
+// Pass nil to accept everything again.
+func (s *Server) RejectPositions(reject func(bushtalk.TrackPayload) bool) {
+	s.mu.Lock()
+	s.reject = reject
+	s.mu.Unlock()
+}
+
+// Positions returns the positions received so far, oldest first
+func (s *Server) Positions() []bushtalk.TrackPayload {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]bushtalk.TrackPayload(nil), s.positions...)
+}
+
+// Requests returns the number of requests served, including failed ones
+func (s *Server) Requests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests
+}
+
+func (s *Server) handleAuthenticate(w http.ResponseWriter, r *http.Request) {
+	if !checkRequest(w, r) {
+		return
+	}
+
+	var req struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON")
+		return
+	}
+
+	s.mu.Lock()
+	password, ok := s.users[req.Username]
+	s.mu.Unlock()
+	if !ok || password != req.Password {
+		writeError(w, http.StatusUnauthorized, "invalid username or password")
+		return
+	}
+
+	s.writeSession(w, req.Username)
+}
+
+func (s *Server) handleRefresh(w http.ResponseWriter, r *http.Request) {
+	if !checkRequest(w, r) {
+		return
+	}
+
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON")
+		return
+	}
+
+	s.mu.Lock()
+	if status := s.refreshStatus; status != 0 {
+		s.mu.Unlock()
+		writeError(w, status, "invalid_grant")
+		return
+	}
+	username, ok := s.refreshTokens[req.RefreshToken]
+	// Refresh tokens are single use and rotated on every refresh
+	delete(s.refreshTokens, req.RefreshToken)
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "invalid refresh token")
+		return
+	}
+
+	s.writeSession(w, username)
+}
+
+func (s *Server) handleTrack(w http.ResponseWriter, r *http.Request) {
+	if !checkRequest(w, r) || !s.checkToken(w, r) {
+		return
+	}
+
+	var payload bushtalk.TrackPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON")
+		return
+	}
+
+	if !s.accept(w, payload) {
+		return
+	}
+	s.mu.Lock()
+	s.positions = append(s.positions, payload)
+	s.mu.Unlock()
+
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *Server) handleTrackBatch(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	noBatch := s.noBatch
+	s.mu.Unlock()
+	if noBatch {
+		http.NotFound(w, r)
+		return
+	}
+
+	if !checkRequest(w, r) || !s.checkToken(w, r) {
+		return
+	}
+
+	var batch struct {
+		Positions []bushtalk.TrackPayload `json:"positions"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON")
+		return
+	}
+
+	if !s.accept(w, batch.Positions...) {
+		return
+	}
+	s.mu.Lock()
+	s.positions = append(s.positions, batch.Positions...)
+	s.mu.Unlock()
+
+	w.WriteHeader(http.StatusCreated)
+}
+
+// accept responds 422 if any of positions is one RejectPositions refuses
+func (s *Server) accept(w http.ResponseWriter, positions ...bushtalk.TrackPayload) bool {
+	s.mu.Lock()
+	reject := s.reject
+	s.mu.Unlock()
+	if reject == nil {
+		return true
+	}
+	for _, p := range positions {
+		if reject(p) {
+			writeError(w, http.StatusUnprocessableEntity, "invalid position")
+			return false
+		}
+	}
+	return true
+}
+
+// writeSession issues new tokens for username and writes the auth response
+func (s *Server) writeSession(w http.ResponseWriter, username string) {
+	s.mu.Lock()
+	resp := bushtalk.AuthResponse{
+		IDToken:      newToken(),
+		RefreshToken: newToken(),
+		UserID:       "user-" + username,
+		Username:     username,
+		ExpiresIn:    int(s.tokenTTL.Seconds()),
+	}
+	s.idTokens[resp.IDToken] = username
+	s.refreshTokens[resp.RefreshToken] = username
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// checkToken validates the bearer token, responding 401 if it's missing or unknown
+func (s *Server) checkToken(w http.ResponseWriter, r *http.Request) bool {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+	s.mu.Lock()
+	_, ok := s.idTokens[token]
+	s.mu.Unlock()
+
+	if token == "" || !ok {
+		writeError(w, http.StatusUnauthorized, "invalid or expired token")
+		return false
+	}
+	return true
+}
+
+// checkRequest validates the method and client identification headers
+func checkRequest(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "POST required")
+		return false
+	}
+	if r.Header.Get("X-BTR-CLIENT-NAME") == "" || r.Header.Get("X-BTR-CLIENT-VERSION") == "" {
+		writeError(w, http.StatusBadRequest, "missing X-BTR-CLIENT-NAME or X-BTR-CLIENT-VERSION header")
+		return false
+	}
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		writeError(w, http.StatusUnsupportedMediaType, "JSON body required")
+		return false
+	}
+	return true
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
+
+func newToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
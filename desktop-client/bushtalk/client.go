@@ -2,9 +2,14 @@ package bushtalk
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -13,11 +18,46 @@ const (
 	ClientVersion = "1.0.0"
 )
 
+// DefaultRefreshPath is the endpoint that exchanges a refresh token for a new
+// ID token, unless SetRefreshPath changes it
+const DefaultRefreshPath = "/api/refresh"
+
+// tokenRefreshMargin is how long before expiry the ID token is proactively refreshed
+const tokenRefreshMargin = 2 * time.Minute
+
+// maxResponseSize limits how much of a response body is read
+const maxResponseSize = 1 << 20
+
 // Client handles communication with the Bushtalk Radio API
 type Client struct {
-	baseURL    string
-	token      string
-	httpClient *http.Client
+	baseURL     string
+	refreshPath string
+	httpClient  *http.Client
+
+	session          Session
+	sessionMu        sync.RWMutex
+	refreshMu        sync.Mutex // serializes token refreshes
+	onSessionRefresh func(Session)
+	batchUnsupported atomic.Bool // server returned 404 for the batch endpoint
+
+	retryPolicy RetryPolicy
+	stats       Stats
+	statsMu     sync.Mutex
+}
+
+// Session holds the tokens of an authenticated user
+type Session struct {
+	IDToken      string
+	RefreshToken string
+	ExpiresAt    time.Time // zero if unknown
+}
+
+// NeedsRefresh returns true if the ID token is expired or about to expire
+func (s Session) NeedsRefresh() bool {
+	if s.ExpiresAt.IsZero() {
+		return false
+	}
+	return time.Until(s.ExpiresAt) < tokenRefreshMargin
 }
 
 // setHeaders adds common headers to all requests
@@ -36,6 +76,18 @@ type AuthResponse struct {
 	ExpiresIn    int    `json:"expires_in"`
 }
 
+// Session converts the auth response into a session, computing the expiry from ExpiresIn
+func (r *AuthResponse) Session() Session {
+	s := Session{
+		IDToken:      r.IDToken,
+		RefreshToken: r.RefreshToken,
+	}
+	if r.ExpiresIn > 0 {
+		s.ExpiresAt = time.Now().Add(time.Duration(r.ExpiresIn) * time.Second)
+	}
+	return s
+}
+
 // TrackPayload represents flight position data sent to the API
 type TrackPayload struct {
 	Latitude       float64 `json:"PLANE_LATITUDE"`
@@ -45,92 +97,370 @@ type TrackPayload struct {
 	Heading        float64 `json:"MAGNETIC_COMPASS"`
 	TailNumber     string  `json:"ATC_ID"`
 	OnGround       bool    `json:"SIM_ON_GROUND"`
+	Timestamp      int64   `json:"TIMESTAMP,omitempty"`       // capture time, Unix milliseconds
+	SimulationRate int     `json:"SIMULATION_RATE,omitempty"` // time acceleration, omitted at 1x
+
+	// Aircraft identity, omitted when the sim doesn't say
+	AircraftICAO   string `json:"ATC_TYPE,omitempty"` // ICAO type designator
+	AircraftTitle  string `json:"TITLE,omitempty"`    // e.g. "Cessna 185 on floats"
+	AircraftAuthor string `json:"AIRCRAFT_AUTHOR,omitempty"`
+	Livery         string `json:"LIVERY_NAME,omitempty"`
+
+	// Extended flight state, omitted by clients that don't have it
+	AltitudeMSL       *float64 `json:"PLANE_ALTITUDE,omitempty"`             // feet
+	IndicatedAirspeed *float64 `json:"AIRSPEED_INDICATED,omitempty"`         // knots
+	VerticalSpeed     *float64 `json:"VERTICAL_SPEED,omitempty"`             // feet per minute
+	TrueHeading       *float64 `json:"PLANE_HEADING_DEGREES_TRUE,omitempty"` // degrees
+	Track             *float64 `json:"GPS_GROUND_TRUE_TRACK,omitempty"`      // degrees true
+	Pitch             *float64 `json:"PLANE_PITCH_DEGREES,omitempty"`        // degrees, positive nose up
+	Bank              *float64 `json:"PLANE_BANK_DEGREES,omitempty"`         // degrees, positive right wing down
 }
 
 // NewClient creates a new Bushtalk API client
 func NewClient(baseURL string) *Client {
+	policy := DefaultRetryPolicy()
 	return &Client{
-		baseURL: baseURL,
+		baseURL:     baseURL,
+		refreshPath: DefaultRefreshPath,
 		httpClient: &http.Client{
-			Timeout: 10 * time.Second,
+			Timeout: policy.Timeout,
 		},
+		retryPolicy: policy,
+	}
+}
+
+// SetRetryPolicy replaces the retry policy. Call before making requests.
+func (c *Client) SetRetryPolicy(policy RetryPolicy) {
+	c.retryPolicy = policy
+	c.httpClient.Timeout = policy.Timeout
+}
+
+// SetRefreshPath sets the token refresh endpoint, or restores
+// DefaultRefreshPath if path is empty. Call before making requests.
+func (c *Client) SetRefreshPath(path string) {
+	if path == "" {
+		path = DefaultRefreshPath
+	}
+	c.refreshPath = path
+}
+
+// Stats returns request attempt counters (thread-safe)
+func (c *Client) Stats() Stats {
+	c.statsMu.Lock()
+	defer c.statsMu.Unlock()
+	return c.stats
+}
+
+// recordAttempt updates the attempt counters after each attempt of a request
+func (c *Client) recordAttempt(attempt int) {
+	c.statsMu.Lock()
+	c.stats.LastAttempts = attempt
+	if attempt > 1 {
+		c.stats.Retries++
+	}
+	c.statsMu.Unlock()
+}
+
+// do sends the request built by newReq, retrying connection errors and
+// 5xx/429 responses according to the retry policy. The response body is read
+// and closed before returning.
+func (c *Client) do(ctx context.Context, op string, newReq func(ctx context.Context) (*http.Request, error)) (*http.Response, []byte, error) {
+	maxAttempts := c.retryPolicy.MaxAttempts
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+
+	for attempt := 1; ; attempt++ {
+		req, err := newReq(ctx)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create %s: %w", op, err)
+		}
+		c.setHeaders(req)
+
+		resp, err := c.httpClient.Do(req)
+		c.recordAttempt(attempt)
+		if ctx.Err() != nil {
+			// Cancelled by the caller, not a network problem
+			if err == nil {
+				resp.Body.Close()
+			}
+			return nil, nil, fmt.Errorf("%s cancelled: %w", op, ctx.Err())
+		}
+
+		var wait time.Duration
+		if err == nil {
+			body, readErr := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+			resp.Body.Close()
+			err = readErr
+
+			retryAfter := parseRetryAfter(resp.Header.Get("Retry-After"))
+			// A Retry-After longer than we'd ever back off is left to the caller
+			if err == nil && (!shouldRetry(resp.StatusCode) || attempt >= maxAttempts || retryAfter > c.retryPolicy.MaxBackoff) {
+				return resp, body, nil
+			}
+			wait = max(c.retryPolicy.backoff(attempt), retryAfter)
+		}
+
+		if err != nil {
+			if attempt >= maxAttempts {
+				return nil, nil, &NetworkError{Op: op, Err: err}
+			}
+			wait = c.retryPolicy.backoff(attempt)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, nil, fmt.Errorf("%s cancelled: %w", op, ctx.Err())
+		case <-time.After(wait):
+		}
+	}
+}
+
+// newAPIError builds the error for an unexpected response status
+func newAPIError(op string, resp *http.Response) *APIError {
+	return &APIError{
+		Op:         op,
+		StatusCode: resp.StatusCode,
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
 	}
 }
 
 // SetToken sets the authentication token for API requests
 func (c *Client) SetToken(token string) {
-	c.token = token
+	c.sessionMu.Lock()
+	c.session.IDToken = token
+	c.sessionMu.Unlock()
 }
 
 // GetToken returns the current authentication token
 func (c *Client) GetToken() string {
-	return c.token
+	c.sessionMu.RLock()
+	defer c.sessionMu.RUnlock()
+	return c.session.IDToken
+}
+
+// SetSession sets the ID token, refresh token and expiry used for API requests
+func (c *Client) SetSession(session Session) {
+	c.sessionMu.Lock()
+	c.session = session
+	c.sessionMu.Unlock()
+}
+
+// GetSession returns the current session (thread-safe)
+func (c *Client) GetSession() Session {
+	c.sessionMu.RLock()
+	defer c.sessionMu.RUnlock()
+	return c.session
+}
+
+// SetOnSessionRefresh sets a callback invoked with the new session after the
+// tokens have been refreshed, so they can be persisted
+func (c *Client) SetOnSessionRefresh(onRefresh func(Session)) {
+	c.onSessionRefresh = onRefresh
 }
 
 // Authenticate logs in with username/password and returns auth response
 func (c *Client) Authenticate(username, password string) (*AuthResponse, error) {
+	return c.AuthenticateContext(context.Background(), username, password)
+}
+
+// AuthenticateContext is like Authenticate but aborts when ctx is cancelled
+func (c *Client) AuthenticateContext(ctx context.Context, username, password string) (*AuthResponse, error) {
 	payload := map[string]string{
 		"username": username,
 		"password": password,
 	}
 
-	body, err := json.Marshal(payload)
+	authResp, err := c.postAuth(ctx, "/api/authenticate", payload)
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal auth request: %w", err)
+		return nil, err
+	}
+
+	c.SetSession(authResp.Session())
+	return authResp, nil
+}
+
+// RefreshSession exchanges the refresh token for a new ID token. The refresh
+// token itself is rotated if the API returns a new one.
+func (c *Client) RefreshSession() error {
+	return c.RefreshSessionContext(context.Background())
+}
+
+// RefreshSessionContext is like RefreshSession but aborts when ctx is
+// cancelled. If the API refuses the refresh with any 4xx status, including a
+// wrong refresh path, the error wraps ErrUnauthorized as the session can't be
+// renewed without logging in again.
+func (c *Client) RefreshSessionContext(ctx context.Context) error {
+	c.refreshMu.Lock()
+	defer c.refreshMu.Unlock()
+
+	current := c.GetSession()
+	if current.RefreshToken == "" {
+		return fmt.Errorf("no refresh token")
 	}
 
-	req, err := http.NewRequest("POST", c.baseURL+"/api/authenticate", bytes.NewReader(body))
+	authResp, err := c.postAuth(ctx, c.refreshPath, map[string]string{
+		"refresh_token": current.RefreshToken,
+	})
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode < 500 && !IsRetryable(err) && !errors.Is(err, ErrUnauthorized) {
+		return fmt.Errorf("token refresh failed: %w: %w", ErrUnauthorized, err)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to create auth request: %w", err)
+		return fmt.Errorf("token refresh failed: %w", err)
 	}
-	c.setHeaders(req)
 
-	resp, err := c.httpClient.Do(req)
+	session := authResp.Session()
+	if session.RefreshToken == "" {
+		session.RefreshToken = current.RefreshToken
+	}
+	c.SetSession(session)
+
+	if c.onSessionRefresh != nil {
+		c.onSessionRefresh(session)
+	}
+	return nil
+}
+
+// postAuth posts to an authentication endpoint and decodes the auth response
+func (c *Client) postAuth(ctx context.Context, path string, payload map[string]string) (*AuthResponse, error) {
+	body, err := json.Marshal(payload)
 	if err != nil {
-		return nil, fmt.Errorf("auth request failed: %w", err)
+		return nil, fmt.Errorf("failed to marshal auth request: %w", err)
+	}
+
+	resp, respBody, err := c.do(ctx, "auth request", func(ctx context.Context) (*http.Request, error) {
+		return http.NewRequestWithContext(ctx, "POST", c.baseURL+path, bytes.NewReader(body))
+	})
+	if err != nil {
+		return nil, err
 	}
-	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("authentication failed: status %d", resp.StatusCode)
+		return nil, newAPIError("authentication", resp)
 	}
 
 	var authResp AuthResponse
-	if err := json.NewDecoder(resp.Body).Decode(&authResp); err != nil {
+	if err := json.Unmarshal(respBody, &authResp); err != nil {
 		return nil, fmt.Errorf("failed to decode auth response: %w", err)
 	}
 
-	c.token = authResp.IDToken
 	return &authResp, nil
 }
 
+// validSession returns the current session, refreshing it first if the ID token
+// is about to expire
+func (c *Client) validSession(ctx context.Context) Session {
+	session := c.GetSession()
+	if session.RefreshToken != "" && session.NeedsRefresh() {
+		if err := c.RefreshSessionContext(ctx); err != nil {
+			// Fall through with the old token; a 401 triggers another attempt
+			return session
+		}
+		session = c.GetSession()
+	}
+	return session
+}
+
 // SendPosition sends flight position data to the tracking API
 func (c *Client) SendPosition(payload *TrackPayload) error {
-	if c.token == "" {
-		return fmt.Errorf("not authenticated")
-	}
+	return c.SendPositionContext(context.Background(), payload)
+}
 
+// SendPositionContext is like SendPosition but aborts when ctx is cancelled
+func (c *Client) SendPositionContext(ctx context.Context, payload *TrackPayload) error {
 	body, err := json.Marshal(payload)
 	if err != nil {
 		return fmt.Errorf("failed to marshal position: %w", err)
 	}
 
-	req, err := http.NewRequest("POST", c.baseURL+"/api/track", bytes.NewReader(body))
-	if err != nil {
-		return fmt.Errorf("failed to create track request: %w", err)
+	return c.postTrack(ctx, "/api/track", "track request", body)
+}
+
+// batchPayload is the request body of the batch track endpoint
+type batchPayload struct {
+	Positions []*TrackPayload `json:"positions"`
+}
+
+// SendPositions sends several positions, oldest first, and returns how many
+// were accepted. Points are posted in one request to the batch endpoint; if
+// the server doesn't have it (404) the client falls back to sending them one
+// at a time for the rest of the session.
+func (c *Client) SendPositions(payloads []*TrackPayload) (int, error) {
+	return c.SendPositionsContext(context.Background(), payloads)
+}
+
+// SendPositionsContext is like SendPositions but aborts when ctx is cancelled
+func (c *Client) SendPositionsContext(ctx context.Context, payloads []*TrackPayload) (int, error) {
+	if len(payloads) == 0 {
+		return 0, nil
 	}
-	c.setHeaders(req)
-	req.Header.Set("Authorization", "Bearer "+c.token)
 
-	resp, err := c.httpClient.Do(req)
+	if !c.batchUnsupported.Load() {
+		body, err := json.Marshal(batchPayload{Positions: payloads})
+		if err != nil {
+			return 0, fmt.Errorf("failed to marshal positions: %w", err)
+		}
+
+		err = c.postTrack(ctx, "/api/track/batch", "batch track request", body)
+		var apiErr *APIError
+		if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound {
+			if err != nil {
+				return 0, err
+			}
+			return len(payloads), nil
+		}
+		c.batchUnsupported.Store(true)
+	}
+
+	for i, payload := range payloads {
+		if err := c.SendPositionContext(ctx, payload); err != nil {
+			return i, err
+		}
+	}
+	return len(payloads), nil
+}
+
+// postTrack posts an encoded body to a tracking endpoint with the session
+// token, refreshing the token once if the API rejects it
+func (c *Client) postTrack(ctx context.Context, path, op string, body []byte) error {
+	session := c.validSession(ctx)
+	if session.IDToken == "" {
+		return fmt.Errorf("not authenticated: %w", ErrUnauthorized)
+	}
+
+	resp, err := c.post(ctx, path, op, body, session.IDToken)
 	if err != nil {
-		return fmt.Errorf("track request failed: %w", err)
+		return err
+	}
+
+	// Token rejected - refresh once and retry
+	if resp.StatusCode == http.StatusUnauthorized && session.RefreshToken != "" {
+		if err := c.RefreshSessionContext(ctx); err != nil {
+			return err
+		}
+		resp, err = c.post(ctx, path, op, body, c.GetToken())
+		if err != nil {
+			return err
+		}
 	}
-	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
-		return fmt.Errorf("track request failed: status %d", resp.StatusCode)
+		return newAPIError(op, resp)
 	}
 
 	return nil
 }
+
+// post sends an authorized POST request, retrying transient failures
+func (c *Client) post(ctx context.Context, path, op string, body []byte, token string) (*http.Response, error) {
+	resp, _, err := c.do(ctx, op, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", c.baseURL+path, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		return req, nil
+	})
+	return resp, err
+}
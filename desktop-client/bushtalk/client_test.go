@@ -0,0 +1,287 @@
+package bushtalk_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/bushtalkradio/xplane-client/bushtalk"
+	"github.com/bushtalkradio/xplane-client/bushtalk/bushtalktest"
+)
+
+// newClient returns a server with one user and a client with fast retries
+func newClient(t *testing.T) (*bushtalktest.Server, *bushtalk.Client) {
+	t.Helper()
+	s := bushtalktest.NewServer()
+	t.Cleanup(s.Close)
+	s.AddUser("pilot", "secret")
+
+	c := bushtalk.NewClient(s.URL)
+	policy := bushtalk.DefaultRetryPolicy()
+	policy.InitialBackoff = time.Millisecond
+	policy.MaxBackoff = 10 * time.Millisecond
+	c.SetRetryPolicy(policy)
+	return s, c
+}
+
+func TestAuthenticate(t *testing.T) {
+	_, c := newClient(t)
+
+	resp, err := c.Authenticate("pilot", "secret")
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	session := c.GetSession()
+	if session.IDToken != resp.IDToken || session.RefreshToken != resp.RefreshToken {
+		t.Errorf("session = %+v, want tokens from %+v", session, resp)
+	}
+	if until := time.Until(session.ExpiresAt); until < 59*time.Minute || until > time.Hour {
+		t.Errorf("ExpiresAt is %v from now, want about an hour", until)
+	}
+}
+
+func TestAuthenticateWrongPassword(t *testing.T) {
+	s, c := newClient(t)
+
+	_, err := c.Authenticate("pilot", "wrong")
+	if !errors.Is(err, bushtalk.ErrUnauthorized) {
+		t.Fatalf("err = %v, want ErrUnauthorized", err)
+	}
+	if n := s.Requests(); n != 1 {
+		t.Errorf("auth failure was retried: %d requests", n)
+	}
+}
+
+func TestSendPosition(t *testing.T) {
+	s, c := newClient(t)
+	if _, err := c.Authenticate("pilot", "secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	payload := &bushtalk.TrackPayload{Latitude: 61.2, Longitude: -149.9, TailNumber: "N185BT", Timestamp: 1700000000000}
+	if err := c.SendPosition(payload); err != nil {
+		t.Fatalf("SendPosition: %v", err)
+	}
+
+	got := s.Positions()
+	if len(got) != 1 || got[0] != *payload {
+		t.Errorf("server received %+v, want %+v", got, *payload)
+	}
+}
+
+func TestSendPositionNotAuthenticated(t *testing.T) {
+	s, c := newClient(t)
+
+	err := c.SendPosition(&bushtalk.TrackPayload{})
+	if !errors.Is(err, bushtalk.ErrUnauthorized) {
+		t.Fatalf("err = %v, want ErrUnauthorized", err)
+	}
+	if n := s.Requests(); n != 0 {
+		t.Errorf("made %d requests without a token", n)
+	}
+}
+
+func TestSendPositionRefreshesExpiredToken(t *testing.T) {
+	s, c := newClient(t)
+	if _, err := c.Authenticate("pilot", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	before := c.GetSession()
+
+	var refreshed bushtalk.Session
+	c.SetOnSessionRefresh(func(s bushtalk.Session) { refreshed = s })
+
+	s.ExpireTokens()
+	if err := c.SendPosition(&bushtalk.TrackPayload{Latitude: 1}); err != nil {
+		t.Fatalf("SendPosition after token expiry: %v", err)
+	}
+
+	if refreshed.IDToken == "" || refreshed.IDToken == before.IDToken {
+		t.Errorf("refresh callback got %+v, want a new ID token", refreshed)
+	}
+	if refreshed.RefreshToken == before.RefreshToken {
+		t.Error("refresh token wasn't rotated")
+	}
+	if len(s.Positions()) != 1 {
+		t.Error("position wasn't delivered after the refresh")
+	}
+}
+
+func TestSendPositionRefreshesBeforeExpiry(t *testing.T) {
+	s, c := newClient(t)
+	s.SetTokenTTL(time.Minute) // inside the refresh margin
+	if _, err := c.Authenticate("pilot", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	before := c.GetToken()
+
+	if err := c.SendPosition(&bushtalk.TrackPayload{}); err != nil {
+		t.Fatalf("SendPosition: %v", err)
+	}
+	if c.GetToken() == before {
+		t.Error("token about to expire wasn't refreshed")
+	}
+}
+
+func TestSetRefreshPath(t *testing.T) {
+	s, c := newClient(t)
+	if _, err := c.Authenticate("pilot", "secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The fake only serves the default path, so refreshing elsewhere fails
+	c.SetRefreshPath("/api/token/refresh")
+	s.ExpireTokens()
+	err := c.SendPosition(&bushtalk.TrackPayload{})
+	var apiErr *bushtalk.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound {
+		t.Fatalf("err = %v, want a 404 from the refresh endpoint", err)
+	}
+	// The session can't be renewed, so the user has to log in again
+	if !errors.Is(err, bushtalk.ErrUnauthorized) {
+		t.Fatalf("err = %v, want ErrUnauthorized", err)
+	}
+
+	c.SetRefreshPath("")
+	if err := c.SendPosition(&bushtalk.TrackPayload{}); err != nil {
+		t.Fatalf("SendPosition after restoring the default path: %v", err)
+	}
+}
+
+func TestSendPositionRefreshRefused(t *testing.T) {
+	s, c := newClient(t)
+	if _, err := c.Authenticate("pilot", "secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	s.FailRefresh(http.StatusBadRequest)
+	s.ExpireTokens()
+	err := c.SendPosition(&bushtalk.TrackPayload{})
+	if !errors.Is(err, bushtalk.ErrUnauthorized) {
+		t.Fatalf("err = %v, want ErrUnauthorized after a 400 from the refresh endpoint", err)
+	}
+}
+
+func TestSendPositionSessionRevoked(t *testing.T) {
+	s, c := newClient(t)
+	if _, err := c.Authenticate("pilot", "secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	s.RevokeSessions()
+	err := c.SendPosition(&bushtalk.TrackPayload{})
+	if !errors.Is(err, bushtalk.ErrUnauthorized) {
+		t.Fatalf("err = %v, want ErrUnauthorized", err)
+	}
+}
+
+func TestSendPositionRetriesServerErrors(t *testing.T) {
+	s, c := newClient(t)
+	if _, err := c.Authenticate("pilot", "secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	s.FailNext(http.StatusInternalServerError, 2, 0)
+	if err := c.SendPosition(&bushtalk.TrackPayload{}); err != nil {
+		t.Fatalf("SendPosition: %v", err)
+	}
+	if got := c.Stats().LastAttempts; got != 3 {
+		t.Errorf("LastAttempts = %d, want 3", got)
+	}
+}
+
+func TestSendPositionGivesUp(t *testing.T) {
+	s, c := newClient(t)
+	if _, err := c.Authenticate("pilot", "secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	s.FailNext(http.StatusServiceUnavailable, 10, 0)
+	err := c.SendPosition(&bushtalk.TrackPayload{})
+	if !errors.Is(err, bushtalk.ErrServerError) || !bushtalk.IsRetryable(err) {
+		t.Fatalf("err = %v, want retryable ErrServerError", err)
+	}
+}
+
+func TestSendPositionRateLimited(t *testing.T) {
+	s, c := newClient(t)
+	if _, err := c.Authenticate("pilot", "secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Longer than MaxBackoff, so it's returned to the caller instead of waited out
+	s.FailNext(http.StatusTooManyRequests, 1, 30*time.Second)
+	err := c.SendPosition(&bushtalk.TrackPayload{})
+	if !errors.Is(err, bushtalk.ErrRateLimited) {
+		t.Fatalf("err = %v, want ErrRateLimited", err)
+	}
+	if got := bushtalk.RetryAfter(err); got != 30*time.Second {
+		t.Errorf("RetryAfter = %v, want 30s", got)
+	}
+}
+
+func TestSendPositionNetworkError(t *testing.T) {
+	s, c := newClient(t)
+	if _, err := c.Authenticate("pilot", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	err := c.SendPosition(&bushtalk.TrackPayload{})
+	if !errors.Is(err, bushtalk.ErrNetwork) {
+		t.Fatalf("err = %v, want ErrNetwork", err)
+	}
+}
+
+func TestSendPositions(t *testing.T) {
+	for _, batch := range []bool{true, false} {
+		s, c := newClient(t)
+		s.DisableBatch(!batch)
+		if _, err := c.Authenticate("pilot", "secret"); err != nil {
+			t.Fatal(err)
+		}
+
+		payloads := []*bushtalk.TrackPayload{{Timestamp: 1}, {Timestamp: 2}, {Timestamp: 3}}
+		sent, err := c.SendPositions(payloads)
+		if err != nil || sent != 3 {
+			t.Fatalf("batch=%v: SendPositions = %d, %v", batch, sent, err)
+		}
+
+		got := s.Positions()
+		if len(got) != 3 || got[0].Timestamp != 1 || got[2].Timestamp != 3 {
+			t.Errorf("batch=%v: server received %+v", batch, got)
+		}
+	}
+}
+
+func TestCancelAbortsRequest(t *testing.T) {
+	s, c := newClient(t)
+	if _, err := c.Authenticate("pilot", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	s.SetLatency(time.Minute)
+
+	for name, call := range map[string]func(ctx context.Context) error{
+		"SendPositionContext": func(ctx context.Context) error {
+			return c.SendPositionContext(ctx, &bushtalk.TrackPayload{})
+		},
+		"AuthenticateContext": func(ctx context.Context) error {
+			_, err := c.AuthenticateContext(ctx, "pilot", "secret")
+			return err
+		},
+	} {
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(50*time.Millisecond, cancel)
+
+		start := time.Now()
+		err := call(ctx)
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("%s: err = %v, want context.Canceled", name, err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("%s took %v to notice the cancellation", name, elapsed)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package bushtalk
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Error kinds returned by Client methods, for use with errors.Is
+var (
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrForbidden    = errors.New("forbidden")
+	ErrRateLimited  = errors.New("rate limited")
+	ErrServerError  = errors.New("server error")
+	ErrNetwork      = errors.New("network error")
+)
+
+// APIError is returned when the API responds with an unexpected status code
+type APIError struct {
+	Op         string // request that failed, e.g. "track request"
+	StatusCode int
+	RetryAfter time.Duration // from the Retry-After header, if any
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s failed: status %d", e.Op, e.StatusCode)
+}
+
+// Unwrap maps the status code to one of the error kinds
+func (e *APIError) Unwrap() error {
+	switch {
+	case e.StatusCode == http.StatusUnauthorized:
+		return ErrUnauthorized
+	case e.StatusCode == http.StatusForbidden:
+		return ErrForbidden
+	case e.StatusCode == http.StatusTooManyRequests:
+		return ErrRateLimited
+	case e.StatusCode >= 500:
+		return ErrServerError
+	}
+	return nil
+}
+
+// NetworkError is returned when a request could not reach the API
+type NetworkError struct {
+	Op  string
+	Err error
+}
+
+func (e *NetworkError) Error() string {
+	return fmt.Sprintf("%s failed: %v", e.Op, e.Err)
+}
+
+// Unwrap returns both ErrNetwork and the underlying transport error
+func (e *NetworkError) Unwrap() []error {
+	return []error{ErrNetwork, e.Err}
+}
+
+// IsRetryable returns true if the request may succeed when sent again later
+func IsRetryable(err error) bool {
+	return errors.Is(err, ErrNetwork) || errors.Is(err, ErrServerError) || errors.Is(err, ErrRateLimited)
+}
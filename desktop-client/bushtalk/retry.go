@@ -0,0 +1,84 @@
+package bushtalk
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy controls how requests that fail with a transient error are retried.
+// Connection errors, 5xx and 429 responses are retried; other 4xx responses,
+// including auth failures, never are.
+type RetryPolicy struct {
+	MaxAttempts    int           // total attempts including the first; 1 disables retries
+	InitialBackoff time.Duration // wait before the second attempt
+	MaxBackoff     time.Duration // upper bound for the exponential backoff
+	Multiplier     float64       // backoff growth factor per attempt
+	Jitter         float64       // fraction of each wait that is randomized (0-1)
+	Timeout        time.Duration // timeout for a single attempt
+}
+
+// DefaultRetryPolicy returns the retry policy used by NewClient
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: 1 * time.Second,
+		MaxBackoff:     30 * time.Second,
+		Multiplier:     2,
+		Jitter:         0.2,
+		Timeout:        10 * time.Second,
+	}
+}
+
+// backoff returns how long to wait after the given failed attempt (1-based)
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := float64(p.InitialBackoff) * math.Pow(p.Multiplier, float64(attempt-1))
+	if p.MaxBackoff > 0 && d > float64(p.MaxBackoff) {
+		d = float64(p.MaxBackoff)
+	}
+	if p.Jitter > 0 {
+		// Spread the wait over [d*(1-jitter), d*(1+jitter)]
+		d += d * p.Jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(d)
+}
+
+// Stats holds request attempt counters for display
+type Stats struct {
+	LastAttempts int // attempts made by the most recent request
+	Retries      int // retries made since the client was created
+}
+
+// RetryAfter returns the wait requested by the server in a 429 or 503
+// response, or zero if err doesn't carry one
+func RetryAfter(err error) time.Duration {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.RetryAfter
+	}
+	return 0
+}
+
+// parseRetryAfter parses a Retry-After header given in seconds or as an HTTP date
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(value); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// shouldRetry returns true if a response status is worth retrying
+func shouldRetry(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500
+}
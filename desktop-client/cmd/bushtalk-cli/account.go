@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/bushtalkradio/xplane-client/bushtalk"
+	"github.com/bushtalkradio/xplane-client/config"
+	"golang.org/x/term"
+)
+
+// loginCommand logs in and saves the session to config.json, like ticking
+// "Remember me" in the desktop app
+func loginCommand(args []string) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	flags := flag.NewFlagSet("login", flag.ExitOnError)
+	username := flags.String("username", cfg.Username, "Bushtalk Radio username (prompted if empty)")
+	apiURL := flags.String("api-url", "", "log in to this API URL and save it")
+	flags.Parse(args)
+
+	if *apiURL != "" {
+		if err := cfg.Set("api_url", *apiURL); err != nil {
+			return err
+		}
+	}
+
+	stdin := bufio.NewReader(os.Stdin)
+	if *username == "" {
+		fmt.Print("Username: ")
+		line, err := readLine(stdin)
+		if err != nil {
+			return fmt.Errorf("failed to read username: %w", err)
+		}
+		*username = strings.TrimSpace(line)
+	}
+
+	password := os.Getenv("BUSHTALK_PASSWORD")
+	if password == "" {
+		fmt.Print("Password: ")
+		password, err = readPassword(stdin)
+		fmt.Println()
+		if err != nil {
+			return fmt.Errorf("failed to read password: %w", err)
+		}
+	}
+	if *username == "" || password == "" {
+		return fmt.Errorf("username and password are required")
+	}
+
+	client := bushtalk.NewClient(cfg.ApiURL)
+	if _, err := client.AuthenticateContext(context.Background(), *username, password); err != nil {
+		return fmt.Errorf("login failed: %w", err)
+	}
+
+	session := client.GetSession()
+	if err := cfg.SaveLogin(*username, session.IDToken, session.RefreshToken, session.ExpiresAt); err != nil {
+		return fmt.Errorf("failed to save config: %w", err)
+	}
+
+	fmt.Printf("Logged in as %s\n", *username)
+	return nil
+}
+
+// logoutCommand forgets the saved login
+func logoutCommand(args []string) error {
+	flags := flag.NewFlagSet("logout", flag.ExitOnError)
+	flags.Parse(args)
+
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	cfg.ClearCredentials()
+	if err := cfg.Save(); err != nil {
+		return fmt.Errorf("failed to save config: %w", err)
+	}
+
+	fmt.Println("Logged out")
+	return nil
+}
+
+// readPassword reads the password without echoing it if stdin is a terminal,
+// or a line from stdin if it's piped in from a script
+func readPassword(stdin *bufio.Reader) (string, error) {
+	if fd := int(os.Stdin.Fd()); term.IsTerminal(fd) {
+		password, err := term.ReadPassword(fd)
+		return string(password), err
+	}
+	return readLine(stdin)
+}
+
+// readLine reads a line without its line ending. The last line of piped
+// input may have no newline, as with "printf secret | bushtalk-cli login".
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err == io.EOF && line != "" {
+		err = nil
+	}
+	return strings.TrimRight(line, "\r\n"), err
+}
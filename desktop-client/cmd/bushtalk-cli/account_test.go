@@ -0,0 +1,25 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestReadLine(t *testing.T) {
+	for _, tt := range []struct {
+		input, want string
+		err         error
+	}{
+		{"secret\n", "secret", nil},
+		{"secret\r\n", "secret", nil},
+		{"secret", "secret", nil}, // printf secret | bushtalk-cli login
+		{"", "", io.EOF},
+	} {
+		got, err := readLine(bufio.NewReader(strings.NewReader(tt.input)))
+		if got != tt.want || err != tt.err {
+			t.Errorf("readLine(%q) = %q, %v; want %q, %v", tt.input, got, err, tt.want, tt.err)
+		}
+	}
+}
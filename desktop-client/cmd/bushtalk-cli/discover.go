@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/bushtalkradio/xplane-client/xplane"
+)
+
+// discoverCommand lists the X-Plane instances announcing themselves on the
+// network
+func discoverCommand(args []string) error {
+	flags := flag.NewFlagSet("discover", flag.ExitOnError)
+	wait := flags.Duration("wait", 3*time.Second, "how long to listen for X-Plane")
+	flags.Parse(args)
+
+	beacons, err := xplane.Discover(context.Background(), *wait)
+	if err != nil {
+		return err
+	}
+	if len(beacons) == 0 {
+		return fmt.Errorf("no X-Plane found on the network within %v", *wait)
+	}
+	printBeacons(beacons)
+	return nil
+}
+
+// printBeacons lists discovered instances, one per line
+func printBeacons(beacons []xplane.Beacon) {
+	for _, b := range beacons {
+		fmt.Printf("  %s, UDP port %d\n", b, b.UDPPort)
+	}
+}
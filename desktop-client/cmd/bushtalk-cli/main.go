@@ -0,0 +1,305 @@
+// Command bushtalk-cli runs and manages the companion without a GUI, for
+// example as a background service on the machine running X-Plane, or to
+// provision a machine from a script.
+//
+// It uses the same config.json as the desktop app:
+//
+//	bushtalk-cli login -username pilot
+//	bushtalk-cli config set xplane_port 8087
+//	bushtalk-cli status
+//	bushtalk-cli discover
+//	bushtalk-cli run -log-file tracker.log
+//	bushtalk-cli logout
+//
+// run is the default command. It uses the saved login if there is one;
+// otherwise pass -username and set the password with -password or the
+// BUSHTALK_PASSWORD environment variable. Status is logged to stdout, or to
+// -log-file. SIGINT and SIGTERM stop tracking cleanly; positions that haven't
+// been sent stay in the queue.
+//
+// For demos and testing, run can fly a recorded flight instead of the
+// simulator with -replay, from CSV, GPX or a capture of X-Plane's Web API
+// made with -capture:
+//
+//	bushtalk-cli run -capture flight.jsonl
+//	bushtalk-cli run -replay flight.jsonl -replay-speed 4
+//
+// run exits once the flight has been played, unless -replay-loop is given.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/bushtalkradio/xplane-client/bushtalk"
+	"github.com/bushtalkradio/xplane-client/config"
+	"github.com/bushtalkradio/xplane-client/replay"
+	"github.com/bushtalkradio/xplane-client/sim"
+	"github.com/bushtalkradio/xplane-client/tracker"
+	"github.com/bushtalkradio/xplane-client/xplane"
+)
+
+// replayFlushTimeout is how long run waits after a replay for the last
+// positions to be sent
+const replayFlushTimeout = 30 * time.Second
+
+const usage = `Usage: bushtalk-cli [command] [flags]
+
+Commands:
+  run            track positions and send them to Bushtalk Radio (default)
+  login          log in and remember the session
+  logout         forget the saved login
+  status         check the simulator and Bushtalk Radio can be reached
+  discover       list X-Plane instances on the network
+  config get     print settings, or one setting by name
+  config set     change a setting
+
+Run "bushtalk-cli <command> -h" for a command's flags.
+`
+
+func main() {
+	cmd, args := "run", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd, args = args[0], args[1:]
+	}
+
+	var err error
+	switch cmd {
+	case "run":
+		err = runCommand(args)
+	case "login":
+		err = loginCommand(args)
+	case "logout":
+		err = logoutCommand(args)
+	case "status":
+		err = statusCommand(args)
+	case "discover":
+		err = discoverCommand(args)
+	case "config":
+		err = configCommand(args)
+	case "help":
+		fmt.Print(usage)
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", cmd, usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "bushtalk-cli %s: %v\n", cmd, err)
+		os.Exit(1)
+	}
+}
+
+// runCommand tracks until interrupted
+func runCommand(args []string) error {
+	flags := flag.NewFlagSet("run", flag.ExitOnError)
+	username := flags.String("username", "", "Bushtalk Radio username (default: saved login)")
+	password := flags.String("password", "", "Bushtalk Radio password (default: $BUSHTALK_PASSWORD)")
+	apiURL := flags.String("api-url", "", "override the API URL from config.json")
+	xplaneHost := flags.String("xplane-host", "", "override the computer running X-Plane from config.json")
+	xplanePort := flags.Int("xplane-port", 0, "override the X-Plane Web API port from config.json")
+	logFile := flags.String("log-file", "", "append status to this file instead of stdout")
+	debug := flags.Bool("debug", false, "log every X-Plane message")
+	replayFile := flags.String("replay", "", "fly a recorded flight (.csv, .gpx or .jsonl capture) instead of the simulator")
+	replaySpeed := flags.Float64("replay-speed", 1, "play -replay this many times faster than real time")
+	replayLoop := flags.Bool("replay-loop", false, "start -replay again when it ends")
+	captureFile := flags.String("capture", "", "record X-Plane's Web API messages to this file for -replay")
+	flags.Parse(args)
+
+	log.SetOutput(os.Stdout)
+	if *logFile != "" {
+		f, err := os.OpenFile(*logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			return fmt.Errorf("failed to open log file: %w", err)
+		}
+		defer f.Close()
+		log.SetOutput(f)
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	if *apiURL != "" {
+		cfg.ApiURL = *apiURL
+	}
+	if *xplaneHost != "" {
+		if err := config.ValidateHost(*xplaneHost); err != nil {
+			return fmt.Errorf("-xplane-host: %w", err)
+		}
+		cfg.XPlaneHost = *xplaneHost
+	}
+	if *xplanePort != 0 {
+		cfg.XPlanePort = *xplanePort
+	}
+	if *debug {
+		cfg.Debug = true
+	}
+	if *password == "" {
+		*password = os.Getenv("BUSHTALK_PASSWORD")
+	}
+
+	if *replayFile != "" && *captureFile != "" {
+		return fmt.Errorf("-capture records the simulator and can't be used with -replay")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var configure []func(t *tracker.Tracker)
+	if *replayFile != "" {
+		if *replaySpeed <= 0 {
+			return fmt.Errorf("-replay-speed must be more than 0")
+		}
+		flight, err := replay.Load(*replayFile)
+		if err != nil {
+			return fmt.Errorf("failed to load flight: %w", err)
+		}
+		log.Printf("Replaying %s (%v) at %gx", *replayFile, flight.Frames[len(flight.Frames)-1].At.Round(time.Second), *replaySpeed)
+		// Stop once the flight has been played and sent, rather than
+		// waiting forever
+		var finished context.CancelFunc
+		ctx, finished = context.WithCancel(ctx)
+		defer finished()
+		var tr *tracker.Tracker
+		var finishOnce sync.Once
+		play := replay.Dialer(flight, *replaySpeed, *replayLoop)
+		dial := func(dialCtx context.Context) (sim.FlightSource, error) {
+			source, err := play(dialCtx)
+			if errors.Is(err, replay.ErrFinished) {
+				finishOnce.Do(func() {
+					go func() {
+						log.Printf("Replay finished")
+						waitForQueue(ctx, tr, replayFlushTimeout)
+						finished()
+					}()
+				})
+			}
+			return source, err
+		}
+		configure = append(configure, func(t *tracker.Tracker) {
+			tr = t
+			t.SetDialer("Replay", dial)
+		})
+	}
+	if *captureFile != "" {
+		f, err := os.Create(*captureFile)
+		if err != nil {
+			return fmt.Errorf("failed to create capture file: %w", err)
+		}
+		defer f.Close()
+		configure = append(configure, func(t *tracker.Tracker) { t.SetCapture(f) })
+	}
+
+	if err := run(ctx, cfg, *username, *password, configure...); err != nil {
+		log.Printf("%v", err)
+		return err
+	}
+	return nil
+}
+
+// waitForQueue waits until t has sent every queued position, ctx is
+// cancelled or timeout passes
+func waitForQueue(ctx context.Context, t *tracker.Tracker, timeout time.Duration) {
+	deadline := time.After(timeout)
+	for t.Queued() > 0 {
+		select {
+		case <-ctx.Done():
+			return
+		case <-deadline:
+			log.Printf("Gave up waiting to send %d positions; they stay queued", t.Queued())
+			return
+		case <-time.After(250 * time.Millisecond):
+		}
+	}
+}
+
+// run tracks until ctx is cancelled or the session can't be renewed. configure
+// is called with the tracker before it starts.
+func run(ctx context.Context, cfg *config.Config, username, password string, configure ...func(t *tracker.Tracker)) error {
+	client := bushtalk.NewClient(cfg.ApiURL)
+	client.SetRefreshPath(cfg.RefreshPath)
+	t := tracker.New(cfg, client, tracker.OpenQueue())
+	for _, fn := range configure {
+		fn(t)
+	}
+
+	// Flags take precedence over a remembered login
+	if username == "" && cfg.HasCredentials() {
+		idToken, refreshToken, expiresAt := cfg.SavedSession()
+		client.SetSession(bushtalk.Session{
+			IDToken:      idToken,
+			RefreshToken: refreshToken,
+			ExpiresAt:    expiresAt,
+		})
+		log.Printf("Using saved login for %s", cfg.Username)
+	} else if err := login(ctx, client, username, password); err != nil {
+		return err
+	}
+
+	expired := make(chan struct{}, 1)
+	t.SetCallbacks(tracker.Callbacks{
+		// Transitions are logged by the tracker itself
+		OnStateChange: func(tr tracker.Transition) {
+			if tr.To != tracker.StateLoggedOut {
+				return
+			}
+			select {
+			case expired <- struct{}{}:
+			default:
+			}
+		},
+		OnPosition: func(pos xplane.Position) {
+			if cfg.Debug {
+				log.Printf("Position: %.4f, %.4f %s", pos.Latitude, pos.Longitude, pos.TailNumber)
+			}
+		},
+		OnSent: func(at time.Time, attempts int) {
+			if attempts > 1 {
+				log.Printf("Sent positions (%d attempts)", attempts)
+			}
+		},
+	})
+
+	log.Printf("Sending positions to %s", cfg.ApiURL)
+	t.Start()
+	for {
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil
+		case <-expired:
+			// The tracker has already stopped; log in again if we can
+			if username == "" || password == "" {
+				return fmt.Errorf("session expired; log in again with -username and a password")
+			}
+			if err := login(ctx, client, username, password); err != nil {
+				return err
+			}
+			t.Start()
+		}
+	}
+}
+
+// login authenticates with username and password, replacing the client's session
+func login(ctx context.Context, client *bushtalk.Client, username, password string) error {
+	if username == "" || password == "" {
+		return fmt.Errorf("not logged in: run \"bushtalk-cli login\" first, or pass -username and a password")
+	}
+
+	if _, err := client.AuthenticateContext(ctx, username, password); err != nil {
+		return fmt.Errorf("login failed: %w", err)
+	}
+	log.Printf("Logged in as %s", username)
+	return nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/bushtalkradio/xplane-client/config"
+)
+
+// configCommand prints or changes settings in config.json
+func configCommand(args []string) error {
+	const usage = "usage: bushtalk-cli config get [key] | config set <key> <value>\nkeys: "
+
+	if len(args) == 0 {
+		return errors.New(usage + strings.Join(config.Keys, ", "))
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	switch {
+	case args[0] == "get" && len(args) == 1:
+		for _, key := range config.Keys {
+			value, _ := cfg.Get(key)
+			fmt.Printf("%s=%s\n", key, value)
+		}
+	case args[0] == "get" && len(args) == 2:
+		value, err := cfg.Get(args[1])
+		if err != nil {
+			return err
+		}
+		fmt.Println(value)
+	case args[0] == "set" && len(args) == 3:
+		if err := cfg.Set(args[1], args[2]); err != nil {
+			return err
+		}
+		if err := cfg.Save(); err != nil {
+			return fmt.Errorf("failed to save config: %w", err)
+		}
+	default:
+		return errors.New(usage + strings.Join(config.Keys, ", "))
+	}
+	return nil
+}
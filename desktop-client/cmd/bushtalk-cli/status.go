@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/bushtalkradio/xplane-client/config"
+	"github.com/bushtalkradio/xplane-client/flightgear"
+	"github.com/bushtalkradio/xplane-client/xplane"
+)
+
+const probeTimeout = 5 * time.Second
+
+// beaconWait is how long status listens for X-Plane beacons. X-Plane sends
+// one a second.
+const beaconWait = 2 * time.Second
+
+// statusCommand checks that the simulator and the Bushtalk Radio API can be
+// reached and reports the saved login. It fails if either can't be reached.
+func statusCommand(args []string) error {
+	flags := flag.NewFlagSet("status", flag.ExitOnError)
+	flags.Parse(args)
+
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	// The probes log their requests; only the summary is wanted here
+	log.SetOutput(io.Discard)
+
+	var simOK bool
+	if cfg.Simulator == "flightgear" {
+		what := fmt.Sprintf("FlightGear (UDP port %d)", cfg.FlightGearPort)
+		err := probe(func(ctx context.Context) error {
+			return probeFlightGear(ctx, cfg.FlightGearPort)
+		})
+		if addrInUse(err) {
+			// The tracker listens on the same port, so it's taken exactly
+			// when tracking works
+			fmt.Printf("%s: port in use, probably by a running client\n", what)
+			simOK = true
+		} else {
+			simOK = report(what, err)
+		}
+	} else {
+		simOK = probeXPlaneAll(cfg)
+	}
+	apiOK := report(fmt.Sprintf("Bushtalk Radio (%s)", cfg.ApiURL), probe(func(ctx context.Context) error {
+		return probeAPI(ctx, cfg.ApiURL)
+	}))
+
+	_, _, expiresAt := cfg.SavedSession()
+	switch {
+	case !cfg.HasCredentials():
+		fmt.Println("Login: not logged in")
+	case expiresAt.IsZero():
+		fmt.Printf("Login: %s\n", cfg.Username)
+	default:
+		// An expired ID token is fine as long as the refresh token still works
+		fmt.Printf("Login: %s (token valid until %s)\n", cfg.Username, expiresAt.Local().Format(time.RFC1123))
+	}
+
+	if !simOK || !apiOK {
+		return fmt.Errorf("not everything is reachable")
+	}
+	return nil
+}
+
+// probeXPlaneAll probes X-Plane the way the tracker connects, and lists any
+// X-Plane heard on the network if that fails
+func probeXPlaneAll(cfg *config.Config) bool {
+	// Auto only needs UDP if the Web API is unreachable
+	xplaneOK := false
+	if cfg.XPlaneSource != xplane.SourceUDP {
+		xplaneOK = report(fmt.Sprintf("X-Plane Web API (%s port %d)", cfg.XPlaneHost, cfg.XPlanePort), probe(func(ctx context.Context) error {
+			return probeXPlane(ctx, cfg.XPlaneHost, cfg.XPlanePort)
+		}))
+	}
+	if !xplaneOK && cfg.XPlaneSource != xplane.SourceWebAPI {
+		xplaneOK = report(fmt.Sprintf("X-Plane UDP (%s port %d)", cfg.XPlaneHost, cfg.XPlaneUDPPort), probe(func(ctx context.Context) error {
+			return probeXPlaneUDP(ctx, cfg.XPlaneHost, cfg.XPlaneUDPPort)
+		}))
+	}
+	if !xplaneOK {
+		// X-Plane on another computer, or on another port, still announces itself
+		if beacons, err := xplane.Discover(context.Background(), beaconWait); err == nil && len(beacons) > 0 {
+			fmt.Println("X-Plane found on the network:")
+			printBeacons(beacons)
+			fmt.Println("Use one with: bushtalk-cli config set xplane_host <address>")
+		}
+	}
+	return xplaneOK
+}
+
+// probe runs check with a timeout
+func probe(check func(ctx context.Context) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+	defer cancel()
+	return check(ctx)
+}
+
+// report prints whether what is reachable and returns true if it is
+func report(what string, err error) bool {
+	if err != nil {
+		fmt.Printf("%s: unreachable: %v\n", what, err)
+		return false
+	}
+	fmt.Printf("%s: reachable\n", what)
+	return true
+}
+
+// probeXPlaneUDP checks X-Plane answers an RREF subscription
+func probeXPlaneUDP(ctx context.Context, host string, port int) error {
+	c := xplane.NewUDPClient(host, port)
+	if err := c.ConnectContext(ctx); err != nil {
+		return err
+	}
+	c.Disconnect()
+	return nil
+}
+
+// probeXPlane checks the Web API answers by resolving a dataref every
+// aircraft has
+func probeXPlane(ctx context.Context, host string, port int) error {
+	_, missing, err := xplane.ResolveDatarefIDsContext(ctx, host, port, []string{xplane.DatarefLatitude})
+	if err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("the Web API doesn't know %s", xplane.DatarefLatitude)
+	}
+	return nil
+}
+
+// probeFlightGear checks FlightGear is sending the generic protocol
+func probeFlightGear(ctx context.Context, port int) error {
+	s := flightgear.NewSource(port)
+	if err := s.ConnectContext(ctx); err != nil {
+		return err
+	}
+	s.Disconnect()
+	return nil
+}
+
+// addrInUse reports whether err is from binding a port another program has.
+// Windows reports WSAEADDRINUSE rather than EADDRINUSE.
+func addrInUse(err error) bool {
+	const wsaeaddrinuse = syscall.Errno(10048)
+	return errors.Is(err, syscall.EADDRINUSE) || errors.Is(err, wsaeaddrinuse)
+}
+
+// probeAPI checks the API server responds. Any HTTP response counts; we're
+// checking the network path, not the account.
+func probeAPI(ctx context.Context, apiURL string) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
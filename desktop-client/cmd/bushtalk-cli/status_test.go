@@ -0,0 +1,21 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+)
+
+func TestProbeFlightGearPortInUse(t *testing.T) {
+	// A running client already listening for FlightGear
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	err = probeFlightGear(context.Background(), conn.LocalAddr().(*net.UDPAddr).Port)
+	if !addrInUse(err) {
+		t.Errorf("err = %v, want the port reported in use", err)
+	}
+}
@@ -0,0 +1,120 @@
+// Command bushtalk-mock serves a local fake of the Bushtalk Radio API so the
+// companion can be developed and tested without bushtalkradio.com.
+//
+// Point the companion at it by setting "api_url" in config.json (or the API
+// URL in Advanced Settings) to http://localhost:8080, then log in with the
+// user given by -user. Failures and latency can be injected at runtime:
+//
+//	curl -X POST 'localhost:8080/mock/fail?status=429&count=3&retry_after=10s'
+//	curl -X POST 'localhost:8080/mock/latency?d=2s'
+//	curl -X POST localhost:8080/mock/expire-tokens
+//	curl -X POST localhost:8080/mock/revoke-sessions
+//	curl -X POST 'localhost:8080/mock/batch?enabled=false'
+//	curl localhost:8080/mock/positions
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bushtalkradio/xplane-client/bushtalk/bushtalktest"
+)
+
+func main() {
+	addr := flag.String("addr", "localhost:8080", "address to listen on")
+	user := flag.String("user", "pilot:pilot", "username:password allowed to log in")
+	latency := flag.Duration("latency", 0, "delay added to every API response")
+	tokenTTL := flag.Duration("token-ttl", time.Hour, "lifetime of issued ID tokens")
+	flag.Parse()
+
+	username, password, ok := strings.Cut(*user, ":")
+	if !ok {
+		log.Fatalf("-user must be username:password")
+	}
+
+	api := bushtalktest.New()
+	api.AddUser(username, password)
+	api.SetLatency(*latency)
+	api.SetTokenTTL(*tokenTTL)
+
+	mux := http.NewServeMux()
+	mux.Handle("/api/", logRequests(api))
+	mux.HandleFunc("/mock/positions", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(api.Positions())
+	})
+	mux.HandleFunc("/mock/fail", func(w http.ResponseWriter, r *http.Request) {
+		status, err := strconv.Atoi(r.URL.Query().Get("status"))
+		if err != nil {
+			http.Error(w, "status required", http.StatusBadRequest)
+			return
+		}
+		count := 1
+		if v := r.URL.Query().Get("count"); v != "" {
+			if count, err = strconv.Atoi(v); err != nil {
+				http.Error(w, "invalid count", http.StatusBadRequest)
+				return
+			}
+		}
+		var retryAfter time.Duration
+		if v := r.URL.Query().Get("retry_after"); v != "" {
+			if retryAfter, err = time.ParseDuration(v); err != nil {
+				http.Error(w, "invalid retry_after", http.StatusBadRequest)
+				return
+			}
+		}
+		api.FailNext(status, count, retryAfter)
+		log.Printf("Failing the next %d requests with status %d", count, status)
+	})
+	mux.HandleFunc("/mock/latency", func(w http.ResponseWriter, r *http.Request) {
+		d, err := time.ParseDuration(r.URL.Query().Get("d"))
+		if err != nil {
+			http.Error(w, "invalid d", http.StatusBadRequest)
+			return
+		}
+		api.SetLatency(d)
+		log.Printf("Latency set to %v", d)
+	})
+	mux.HandleFunc("/mock/expire-tokens", func(w http.ResponseWriter, r *http.Request) {
+		api.ExpireTokens()
+		log.Printf("ID tokens expired")
+	})
+	mux.HandleFunc("/mock/revoke-sessions", func(w http.ResponseWriter, r *http.Request) {
+		api.RevokeSessions()
+		log.Printf("All sessions revoked")
+	})
+	mux.HandleFunc("/mock/batch", func(w http.ResponseWriter, r *http.Request) {
+		enabled := r.URL.Query().Get("enabled") != "false"
+		api.DisableBatch(!enabled)
+		log.Printf("Batch endpoint enabled: %v", enabled)
+	})
+
+	fmt.Printf("Mock Bushtalk Radio API on http://%s (user %q)\n", *addr, username)
+	log.Fatal(http.ListenAndServe(*addr, mux))
+}
+
+// statusRecorder captures the response status for logging
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// logRequests logs each API request with its response status
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		log.Printf("%s %s -> %d", r.Method, r.URL.Path, rec.status)
+	})
+}
@@ -5,25 +5,58 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"sync"
+	"time"
 )
 
 // Config holds application configuration
 type Config struct {
-	Username    string `json:"username,omitempty"`
-	ApiToken    string `json:"api_token,omitempty"`
-	ApiURL      string `json:"api_url"`
-	XPlanePort  int    `json:"xplane_port"`
-	ShowConsole bool   `json:"show_console"`
+	Username       string     `json:"username,omitempty"`
+	ApiToken       string     `json:"api_token,omitempty"`
+	RefreshToken   string     `json:"refresh_token,omitempty"`
+	TokenExpiry    *time.Time `json:"token_expiry,omitempty"`
+	ApiURL         string     `json:"api_url"`
+	RefreshPath    string     `json:"refresh_path,omitempty"` // token refresh endpoint; empty for bushtalk.DefaultRefreshPath
+	Simulator      string     `json:"simulator"`              // "xplane" or "flightgear"
+	XPlaneHost     string     `json:"xplane_host"`            // computer running X-Plane
+	XPlanePort     int        `json:"xplane_port"`
+	XPlaneSource   string     `json:"xplane_source"` // "auto", "webapi" or "udp"; see xplane.SourceAuto
+	XPlaneUDPPort  int        `json:"xplane_udp_port"`
+	FlightGearPort int        `json:"flightgear_port"` // UDP port FlightGear sends to
+	ShowConsole    bool       `json:"show_console"`
+	Debug          bool       `json:"debug,omitempty"` // log every X-Plane message
+
+	// StaleTimeoutSeconds is how long the simulator may go without sending updates
+	// before the connection is treated as stalled; 0 disables the check
+	StaleTimeoutSeconds int `json:"stale_timeout_seconds"`
+
+	// mu guards the saved login and Save. The tracker saves refreshed tokens
+	// from its own goroutine.
+	mu sync.Mutex
 }
 
 // DefaultConfig returns configuration with default values
 func DefaultConfig() *Config {
 	return &Config{
-		ApiURL:     "https://bushtalkradio.com",
-		XPlanePort: 8086,
+		ApiURL:              "https://bushtalkradio.com",
+		Simulator:           "xplane",
+		XPlaneHost:          "localhost",
+		XPlanePort:          8086,
+		XPlaneSource:        "auto",
+		XPlaneUDPPort:       49000,
+		FlightGearPort:      5505,
+		StaleTimeoutSeconds: 30,
 	}
 }
 
+// SimulatorName returns the selected simulator's name for display
+func (c *Config) SimulatorName() string {
+	if c.Simulator == "flightgear" {
+		return "FlightGear"
+	}
+	return "X-Plane"
+}
+
 // configDir returns the appropriate config directory for the OS
 func configDir() (string, error) {
 	var dir string
@@ -55,6 +88,11 @@ func configDir() (string, error) {
 	return dir, nil
 }
 
+// Dir returns the directory holding config.json and other application data
+func Dir() (string, error) {
+	return configDir()
+}
+
 // configPath returns the path to config.json
 func configPath() (string, error) {
 	dir, err := configDir()
@@ -90,6 +128,13 @@ func Load() (*Config, error) {
 
 // Save writes configuration to config.json
 func (c *Config) Save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.save()
+}
+
+// save writes config.json; c.mu must be held
+func (c *Config) save() error {
 	path, err := configPath()
 	if err != nil {
 		return err
@@ -109,13 +154,80 @@ func (c *Config) Save() error {
 	return os.WriteFile(path, data, 0600)
 }
 
+// StaleTimeout returns StaleTimeoutSeconds as a duration
+func (c *Config) StaleTimeout() time.Duration {
+	return time.Duration(c.StaleTimeoutSeconds) * time.Second
+}
+
 // HasCredentials returns true if username and token are saved
 func (c *Config) HasCredentials() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hasCredentials()
+}
+
+func (c *Config) hasCredentials() bool {
 	return c.Username != "" && c.ApiToken != ""
 }
 
+// SavedSession returns the saved tokens. expiresAt is zero if unknown.
+func (c *Config) SavedSession() (idToken, refreshToken string, expiresAt time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.TokenExpiry != nil {
+		expiresAt = *c.TokenExpiry
+	}
+	return c.ApiToken, c.RefreshToken, expiresAt
+}
+
+// SaveLogin remembers username's tokens and saves the config
+func (c *Config) SaveLogin(username, idToken, refreshToken string, expiresAt time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Username = username
+	c.setTokens(idToken, refreshToken, expiresAt)
+	return c.save()
+}
+
+// UpdateTokens replaces the saved tokens after a refresh and saves the
+// config. It does nothing unless a login is saved, so a session the user
+// chose not to remember isn't written to disk.
+func (c *Config) UpdateTokens(idToken, refreshToken string, expiresAt time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.hasCredentials() {
+		return nil
+	}
+	c.setTokens(idToken, refreshToken, expiresAt)
+	return c.save()
+}
+
+// ForgetTokens removes the saved tokens but keeps the username, and saves
+// the config if there were any
+func (c *Config) ForgetTokens() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ApiToken == "" {
+		return nil
+	}
+	c.setTokens("", "", time.Time{})
+	return c.save()
+}
+
 // ClearCredentials removes saved credentials
 func (c *Config) ClearCredentials() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.Username = ""
-	c.ApiToken = ""
+	c.setTokens("", "", time.Time{})
+}
+
+// setTokens stores the tokens; c.mu must be held
+func (c *Config) setTokens(idToken, refreshToken string, expiresAt time.Time) {
+	c.ApiToken = idToken
+	c.RefreshToken = refreshToken
+	c.TokenExpiry = nil
+	if !expiresAt.IsZero() {
+		c.TokenExpiry = &expiresAt
+	}
 }
@@ -0,0 +1,31 @@
+package config
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTokenExpiryOmittedWhenUnknown(t *testing.T) {
+	cfg := DefaultConfig()
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "token_expiry") {
+		t.Errorf("config without a login has a token expiry: %s", data)
+	}
+
+	expiry := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	cfg.Username = "pilot"
+	cfg.setTokens("id", "refresh", expiry)
+	data, _ = json.Marshal(cfg)
+	var loaded Config
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, got := loaded.SavedSession(); !got.Equal(expiry) {
+		t.Errorf("expiry after reload = %v, want %v", got, expiry)
+	}
+}
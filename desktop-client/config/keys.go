@@ -0,0 +1,155 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Keys lists the settings that can be read and changed with Get and Set, by
+// their config.json names. Tokens are deliberately left out; they are managed
+// by logging in and out.
+var Keys = []string{"username", "api_url", "refresh_path", "simulator", "xplane_host", "xplane_port", "xplane_source", "xplane_udp_port", "flightgear_port", "show_console", "debug", "stale_timeout_seconds"}
+
+// Get returns the value of the setting called key
+func (c *Config) Get(key string) (string, error) {
+	switch key {
+	case "username":
+		return c.Username, nil
+	case "api_url":
+		return c.ApiURL, nil
+	case "refresh_path":
+		return c.RefreshPath, nil
+	case "simulator":
+		return c.Simulator, nil
+	case "xplane_host":
+		return c.XPlaneHost, nil
+	case "xplane_port":
+		return strconv.Itoa(c.XPlanePort), nil
+	case "xplane_source":
+		return c.XPlaneSource, nil
+	case "xplane_udp_port":
+		return strconv.Itoa(c.XPlaneUDPPort), nil
+	case "flightgear_port":
+		return strconv.Itoa(c.FlightGearPort), nil
+	case "show_console":
+		return strconv.FormatBool(c.ShowConsole), nil
+	case "debug":
+		return strconv.FormatBool(c.Debug), nil
+	case "stale_timeout_seconds":
+		return strconv.Itoa(c.StaleTimeoutSeconds), nil
+	}
+	return "", fmt.Errorf("unknown setting %q", key)
+}
+
+// Set validates value and stores it in the setting called key. It doesn't
+// save the config.
+func (c *Config) Set(key, value string) error {
+	switch key {
+	case "username":
+		if value != c.Username {
+			// A saved session belongs to the old user
+			c.ClearCredentials()
+		}
+		c.Username = value
+	case "api_url":
+		u, err := url.Parse(value)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("api_url must be an http or https URL")
+		}
+		c.ApiURL = value
+	case "refresh_path":
+		if value != "" && !strings.HasPrefix(value, "/") {
+			return fmt.Errorf("refresh_path must be a path starting with /, or empty for the default")
+		}
+		c.RefreshPath = value
+	case "simulator":
+		if value != "xplane" && value != "flightgear" {
+			return fmt.Errorf("simulator must be xplane or flightgear")
+		}
+		c.Simulator = value
+	case "xplane_host":
+		if err := ValidateHost(value); err != nil {
+			return fmt.Errorf("xplane_host: %w", err)
+		}
+		c.XPlaneHost = value
+	case "xplane_port":
+		port, err := strconv.Atoi(value)
+		if err != nil || port <= 0 || port > 65535 {
+			return fmt.Errorf("xplane_port must be a port number")
+		}
+		c.XPlanePort = port
+	case "xplane_source":
+		if value != "auto" && value != "webapi" && value != "udp" {
+			return fmt.Errorf("xplane_source must be auto, webapi or udp")
+		}
+		c.XPlaneSource = value
+	case "xplane_udp_port":
+		port, err := strconv.Atoi(value)
+		if err != nil || port <= 0 || port > 65535 {
+			return fmt.Errorf("xplane_udp_port must be a port number")
+		}
+		c.XPlaneUDPPort = port
+	case "flightgear_port":
+		port, err := strconv.Atoi(value)
+		if err != nil || port <= 0 || port > 65535 {
+			return fmt.Errorf("flightgear_port must be a port number")
+		}
+		c.FlightGearPort = port
+	case "show_console":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("show_console must be true or false")
+		}
+		c.ShowConsole = b
+	case "debug":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("debug must be true or false")
+		}
+		c.Debug = b
+	case "stale_timeout_seconds":
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds < 0 {
+			return fmt.Errorf("stale_timeout_seconds must be a number of seconds, or 0 to disable")
+		}
+		c.StaleTimeoutSeconds = seconds
+	default:
+		return fmt.Errorf("unknown setting %q", key)
+	}
+	return nil
+}
+
+// ValidateHost checks host is a host name or IP address on its own, as the
+// X-Plane host must be; a URL or a port is a common mistake
+func ValidateHost(host string) error {
+	if host == "" {
+		return errors.New("enter the name or IP address of the computer running X-Plane")
+	}
+	if strings.Contains(host, "://") || strings.Contains(host, "/") {
+		return errors.New("enter just the computer's name or IP address, without http:// or a path")
+	}
+	if net.ParseIP(host) != nil {
+		return nil
+	}
+	if strings.Contains(host, ":") {
+		return errors.New("enter the port separately")
+	}
+	if len(host) > 253 {
+		return errors.New("host name is too long")
+	}
+	for _, label := range strings.Split(host, ".") {
+		if len(label) == 0 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return fmt.Errorf("%q is not a valid host name", host)
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-') {
+				return fmt.Errorf("%q is not a valid host name", host)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package config
+
+import "testing"
+
+func TestSet(t *testing.T) {
+	tests := []struct {
+		key, value string
+		wantErr    bool
+	}{
+		{"xplane_port", "8087", false},
+		{"xplane_port", "0", true},
+		{"xplane_port", "70000", true},
+		{"xplane_port", "abc", true},
+		{"simulator", "flightgear", false},
+		{"simulator", "msfs", true},
+		{"flightgear_port", "5510", false},
+		{"xplane_host", "192.168.1.20", false},
+		{"xplane_host", "sim-rig.local", false},
+		{"xplane_host", "fe80::1", false},
+		{"xplane_host", "", true},
+		{"xplane_host", "http://sim-rig", true},
+		{"xplane_host", "sim-rig:8086", true},
+		{"xplane_host", "sim rig", true},
+		{"xplane_source", "udp", false},
+		{"xplane_source", "tcp", true},
+		{"xplane_udp_port", "49001", false},
+		{"api_url", "http://localhost:8080", false},
+		{"api_url", "localhost:8080", true},
+		{"refresh_path", "/api/auth/refresh", false},
+		{"refresh_path", "", false},
+		{"refresh_path", "api/refresh", true},
+		{"debug", "true", false},
+		{"debug", "maybe", true},
+		{"stale_timeout_seconds", "0", false},
+		{"stale_timeout_seconds", "-1", true},
+		{"api_token", "secret", true},
+	}
+
+	for _, tt := range tests {
+		cfg := DefaultConfig()
+		err := cfg.Set(tt.key, tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Set(%q, %q) error = %v, want error %v", tt.key, tt.value, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if got, _ := cfg.Get(tt.key); got != tt.value {
+			t.Errorf("Get(%q) = %q after Set, want %q", tt.key, got, tt.value)
+		}
+	}
+}
+
+func TestSetUsernameClearsSession(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Username = "pilot"
+	cfg.ApiToken = "token"
+
+	if err := cfg.Set("username", "other"); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.ApiToken != "" {
+		t.Errorf("ApiToken = %q, want it cleared for a new user", cfg.ApiToken)
+	}
+}
@@ -0,0 +1,90 @@
+package flightgear
+
+import (
+	_ "embed"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bushtalkradio/xplane-client/sim"
+)
+
+// ProtocolXML is the generic protocol definition FlightGear needs in
+// $FG_ROOT/Protocol/bushtalk.xml to send what Source expects
+//
+//go:embed bushtalk.xml
+var ProtocolXML []byte
+
+// field is one chunk of the protocol, in the order FlightGear sends them
+type field struct {
+	node  string
+	apply func(p *sim.Position, v string) error
+}
+
+var fields = []field{
+	{"/position/latitude-deg", number(func(p *sim.Position, v float64) { p.Latitude = v })},
+	{"/position/longitude-deg", number(func(p *sim.Position, v float64) { p.Longitude = v })},
+	{"/position/altitude-ft", number(func(p *sim.Position, v float64) { p.AltitudeMSL = v * 0.3048 })},
+	{"/position/altitude-agl-ft", number(func(p *sim.Position, v float64) { p.AltitudeAGL = v * 0.3048 })},
+	{"/velocities/groundspeed-kt", number(func(p *sim.Position, v float64) { p.Groundspeed = v / 1.94384 })},
+	{"/orientation/heading-magnetic-deg", number(func(p *sim.Position, v float64) { p.Heading = v })},
+	{"/orientation/heading-deg", number(func(p *sim.Position, v float64) { p.TrueHeading = v })},
+	{"/orientation/track-deg", number(func(p *sim.Position, v float64) { p.Track = v })},
+	{"/instrumentation/airspeed-indicator/indicated-speed-kt", number(func(p *sim.Position, v float64) { p.IndicatedAirspeed = v })},
+	{"/velocities/vertical-speed-fps", number(func(p *sim.Position, v float64) { p.VerticalSpeed = v * 60 })},
+	{"/orientation/pitch-deg", number(func(p *sim.Position, v float64) { p.Pitch = v })},
+	{"/orientation/roll-deg", number(func(p *sim.Position, v float64) { p.Roll = v })},
+	// On the ground if any of the first three gear are
+	{"/gear/gear[0]/wow", number(setWeightOnWheels)},
+	{"/gear/gear[1]/wow", number(setWeightOnWheels)},
+	{"/gear/gear[2]/wow", number(setWeightOnWheels)},
+	{"/sim/freeze/master", number(func(p *sim.Position, v float64) { p.Paused = v != 0 })},
+	{"/sim/replay/replay-state", number(func(p *sim.Position, v float64) { p.Replay = v != 0 })},
+	{"/sim/speed-up", number(func(p *sim.Position, v float64) { p.SimSpeed = int(math.Round(v)) })},
+	{"/sim/multiplay/callsign", text(func(p *sim.Position, v string) { p.TailNumber = v })},
+	{"/sim/description", text(func(p *sim.Position, v string) { p.AircraftDescription = v })},
+	{"/sim/author", text(func(p *sim.Position, v string) { p.AircraftAuthor = v })},
+}
+
+func setWeightOnWheels(p *sim.Position, v float64) {
+	p.OnGround = p.OnGround || v != 0
+	p.HasOnGround = true
+}
+
+func number(set func(p *sim.Position, v float64)) func(*sim.Position, string) error {
+	return func(p *sim.Position, v string) error {
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			return err
+		}
+		set(p, f)
+		return nil
+	}
+}
+
+func text(set func(p *sim.Position, v string)) func(*sim.Position, string) error {
+	return func(p *sim.Position, v string) error {
+		set(p, strings.TrimSpace(v))
+		return nil
+	}
+}
+
+// ParseLine decodes one line of the generic protocol: the values of the
+// fields in order, separated by tabs
+func ParseLine(line string) (sim.Position, error) {
+	values := strings.Split(strings.TrimRight(line, "\r\n"), "\t")
+	if len(values) != len(fields) {
+		return sim.Position{}, fmt.Errorf("got %d values, want %d; is $FG_ROOT/Protocol/bushtalk.xml up to date?", len(values), len(fields))
+	}
+
+	var p sim.Position
+	for i, f := range fields {
+		if err := f.apply(&p, values[i]); err != nil {
+			return sim.Position{}, fmt.Errorf("bad value for %s: %w", f.node, err)
+		}
+	}
+	p.Timestamp = time.Now()
+	return p, nil
+}
@@ -0,0 +1,26 @@
+package flightgear
+
+import (
+	"encoding/xml"
+	"testing"
+)
+
+// The shipped XML must list the properties in the order ParseLine reads them
+func TestProtocolXMLMatchesFields(t *testing.T) {
+	var doc struct {
+		Chunks []struct {
+			Node string `xml:"node"`
+		} `xml:"generic>output>chunk"`
+	}
+	if err := xml.Unmarshal(ProtocolXML, &doc); err != nil {
+		t.Fatalf("bushtalk.xml: %v", err)
+	}
+	if len(doc.Chunks) != len(fields) {
+		t.Fatalf("bushtalk.xml has %d chunks, ParseLine reads %d", len(doc.Chunks), len(fields))
+	}
+	for i, chunk := range doc.Chunks {
+		if chunk.Node != fields[i].node {
+			t.Errorf("chunk %d is %s, want %s", i, chunk.Node, fields[i].node)
+		}
+	}
+}
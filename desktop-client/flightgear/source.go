@@ -0,0 +1,145 @@
+// Package flightgear reads positions from FlightGear over its generic
+// protocol. FlightGear sends a line of tab-separated properties, as listed in
+// bushtalk.xml, to a UDP port at a fixed rate. Copy bushtalk.xml to
+// $FG_ROOT/Protocol and start FlightGear with
+//
+//	--generic=socket,out,5,localhost,5505,udp,bushtalk
+//
+// replacing localhost with the companion's address if it runs on another
+// computer.
+package flightgear
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bushtalkradio/xplane-client/sim"
+)
+
+// DefaultPort is the UDP port Source listens on
+const DefaultPort = 5505
+
+// firstLine is how long to wait for FlightGear to start sending
+const firstLine = 3 * time.Second
+
+// Source listens for FlightGear's generic protocol output
+type Source struct {
+	port         int
+	staleTimeout time.Duration
+	udp          *sim.UDPListener
+
+	position   sim.Position
+	positionMu sync.RWMutex
+	badLine    bool // a malformed line has been logged
+}
+
+// NewSource creates a source listening on UDP port
+func NewSource(port int) *Source {
+	return &Source{
+		port: port,
+		udp:  sim.NewUDPListener("FlightGear"),
+	}
+}
+
+// Dialer returns a sim.Dialer that listens on port, treating the connection
+// as lost after staleTimeout without data (0 waits forever)
+func Dialer(port int, staleTimeout time.Duration) sim.Dialer {
+	return func(ctx context.Context) (sim.FlightSource, error) {
+		s := NewSource(port)
+		s.SetStaleTimeout(staleTimeout)
+		if err := s.ConnectContext(ctx); err != nil {
+			return nil, err
+		}
+		return s, nil
+	}
+}
+
+// SetStaleTimeout sets how long without data before the connection is
+// treated as lost. 0 disables the check. Call before Connect.
+func (s *Source) SetStaleTimeout(d time.Duration) {
+	s.staleTimeout = d
+}
+
+// ConnectContext starts listening and waits for FlightGear's first line
+func (s *Source) ConnectContext(ctx context.Context) error {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: s.port})
+	if err != nil {
+		return fmt.Errorf("failed to listen on UDP port %d: %w", s.port, err)
+	}
+	if err := s.udp.Open(conn); err != nil {
+		return err
+	}
+
+	err = s.udp.Await(ctx, firstLine, func(packet []byte) (bool, error) {
+		return true, s.handlePacket(packet)
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return err
+		}
+		return fmt.Errorf("nothing from FlightGear on UDP port %d: %w", s.port, err)
+	}
+
+	s.udp.Serve(s.staleTimeout, func(packet []byte) {
+		if err := s.handlePacket(packet); err != nil && !s.badLine {
+			// Sent several times a second; once is enough
+			log.Printf("Ignoring data from FlightGear: %v", err)
+			s.badLine = true
+		}
+	})
+	return nil
+}
+
+// handlePacket applies the last complete line in packet
+func (s *Source) handlePacket(packet []byte) error {
+	lines := strings.Split(strings.TrimRight(string(packet), "\r\n"), "\n")
+	p, err := ParseLine(lines[len(lines)-1])
+	if err != nil {
+		return err
+	}
+	s.positionMu.Lock()
+	s.position = p
+	s.positionMu.Unlock()
+	return nil
+}
+
+// Done returns a channel that's closed when the connection is lost
+func (s *Source) Done() <-chan struct{} {
+	return s.udp.Done()
+}
+
+// Snapshot returns the latest position
+func (s *Source) Snapshot() sim.Position {
+	s.positionMu.RLock()
+	defer s.positionMu.RUnlock()
+	return s.position
+}
+
+// Capabilities reports what bushtalk.xml asks FlightGear for. FlightGear has
+// no ICAO type designator or livery property.
+func (s *Source) Capabilities() sim.Capabilities {
+	return sim.Capabilities{
+		Name:          "FlightGear",
+		OnGround:      true,
+		SimState:      true,
+		AircraftTitle: true,
+		FlightState:   true,
+	}
+}
+
+// IsConnected returns true while FlightGear is sending data
+func (s *Source) IsConnected() bool {
+	return s.udp.IsConnected()
+}
+
+// Disconnect stops listening. It's safe to call more than once.
+func (s *Source) Disconnect() {
+	s.udp.Close(nil)
+}
+
+var _ sim.FlightSource = (*Source)(nil)
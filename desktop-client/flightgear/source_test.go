@@ -0,0 +1,137 @@
+package flightgear_test
+
+import (
+	"context"
+	"math"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bushtalkradio/xplane-client/flightgear"
+)
+
+// line is a generic protocol line for a C172 climbing out, with gear 1 on
+// the ground as given
+var line = strings.Join([]string{
+	"61.2176000", "-149.8997000", // lat, lon
+	"1000.0", "500.0", // altitude MSL and AGL, ft
+	"100.0",                 // groundspeed, kt
+	"90.0", "108.0", "95.0", // magnetic heading, true heading, track
+	"95.0", "8.33", // IAS, vertical speed fps
+	"5.0", "-2.0", // pitch, roll
+	"0", "0", "0", // weight on wheels
+	"0", "0", "4.00", // paused, replay, speed-up
+	"N172BT", "Cessna C172P Skyhawk", "David Megginson",
+}, "\t")
+
+func TestParseLine(t *testing.T) {
+	p, err := flightgear.ParseLine(line + "\n")
+	if err != nil {
+		t.Fatalf("ParseLine: %v", err)
+	}
+	if p.Latitude != 61.2176 || p.Longitude != -149.8997 || p.TailNumber != "N172BT" {
+		t.Errorf("position = %+v", p)
+	}
+	if math.Abs(p.AltitudeAGL-152.4) > 0.01 || math.Abs(p.Groundspeed*1.94384-100) > 0.01 {
+		t.Errorf("AGL = %v m, groundspeed = %v m/s", p.AltitudeAGL, p.Groundspeed)
+	}
+	if math.Abs(p.VerticalSpeed-499.8) > 0.01 || p.TrueHeading != 108 || p.Roll != -2 {
+		t.Errorf("flight state = %+v", p)
+	}
+	if !p.HasOnGround || p.IsOnGround() {
+		t.Error("on ground with no weight on wheels")
+	}
+	if p.TimeAcceleration() != 4 || p.AircraftDescription != "Cessna C172P Skyhawk" {
+		t.Errorf("rate = %d, description = %q", p.TimeAcceleration(), p.AircraftDescription)
+	}
+
+	onGround, _ := flightgear.ParseLine(strings.Replace(line, "0\t0\t0\t0", "0\t1\t0\t0", 1))
+	if !onGround.IsOnGround() {
+		t.Error("not on ground with weight on gear 1")
+	}
+
+	if _, err := flightgear.ParseLine("61.2\t-149.8"); err == nil {
+		t.Error("ParseLine accepted a short line")
+	}
+}
+
+// freePort finds a UDP port nothing is listening on
+func freePort(t *testing.T) int {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+// sendLines sends line to port until the test ends, like FlightGear would
+func sendLines(t *testing.T, port int, line string) {
+	conn, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port})
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	t.Cleanup(func() {
+		close(done)
+		<-stopped
+		conn.Close()
+	})
+	go func() {
+		defer close(stopped)
+		for {
+			conn.Write([]byte(line + "\n"))
+			select {
+			case <-done:
+				return
+			case <-time.After(20 * time.Millisecond):
+			}
+		}
+	}()
+}
+
+func TestSource(t *testing.T) {
+	port := freePort(t)
+	sendLines(t, port, line)
+
+	s := flightgear.NewSource(port)
+	s.SetStaleTimeout(time.Second)
+	if err := s.ConnectContext(context.Background()); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	defer s.Disconnect()
+
+	if p := s.Snapshot(); p.TailNumber != "N172BT" || !s.IsConnected() {
+		t.Errorf("Snapshot() = %+v", p)
+	}
+	s.Disconnect()
+	select {
+	case <-s.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("Done not closed after Disconnect")
+	}
+}
+
+func TestSourceRejectsOtherProtocols(t *testing.T) {
+	port := freePort(t)
+	sendLines(t, port, "61.2\t-149.8\t1000")
+
+	s := flightgear.NewSource(port)
+	if err := s.ConnectContext(context.Background()); err == nil {
+		s.Disconnect()
+		t.Fatal("Connect succeeded with lines from another protocol")
+	}
+}
+
+func TestSourceNothingSent(t *testing.T) {
+	s := flightgear.NewSource(freePort(t))
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := s.ConnectContext(ctx); err == nil {
+		s.Disconnect()
+		t.Fatal("Connect succeeded without FlightGear")
+	}
+}
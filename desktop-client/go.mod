@@ -5,6 +5,7 @@ go 1.21
 require (
 	fyne.io/fyne/v2 v2.4.4
 	github.com/gorilla/websocket v1.5.1
+	golang.org/x/term v0.13.0
 )
 
 require (
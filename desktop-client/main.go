@@ -1,7 +1,9 @@
 package main
 
 import (
+	"context"
 	"log"
+	"sync"
 	"time"
 
 	"fyne.io/fyne/v2"
@@ -9,23 +11,24 @@ import (
 
 	"github.com/bushtalkradio/xplane-client/bushtalk"
 	"github.com/bushtalkradio/xplane-client/config"
+	"github.com/bushtalkradio/xplane-client/tracker"
 	"github.com/bushtalkradio/xplane-client/ui"
 	"github.com/bushtalkradio/xplane-client/xplane"
 )
 
-const (
-	trackInterval = 5 * time.Second
-	reconnectDelay = 5 * time.Second
-)
-
 type App struct {
 	fyneApp        fyne.App
 	cfg            *config.Config
 	bushtalkClient *bushtalk.Client
-	xplaneClient   *xplane.Client
+	tracker        *tracker.Tracker
 	loginWindow    *ui.LoginWindow
-	statusWindow   *ui.StatusWindow
-	stopCh         chan struct{}
+
+	// Shown while logged in; the tracker and beacon goroutines use it too
+	statusWindow *ui.StatusWindow
+	windowMu     sync.Mutex // guards statusWindow
+
+	beacons   []xplane.Beacon // X-Plane instances heard on the network
+	beaconsMu sync.Mutex      // guards beacons
 }
 
 func main() {
@@ -49,177 +52,132 @@ func main() {
 		cfg:     cfg,
 	}
 
-	// Initialize Bushtalk client
+	// Initialize Bushtalk client and the tracker that feeds it
 	a.bushtalkClient = bushtalk.NewClient(cfg.ApiURL)
+	a.bushtalkClient.SetRefreshPath(cfg.RefreshPath)
+	a.tracker = tracker.New(cfg, a.bushtalkClient, tracker.OpenQueue())
+	a.tracker.SetCallbacks(tracker.Callbacks{
+		OnStateChange: func(t tracker.Transition) {
+			if t.To == tracker.StateLoggedOut {
+				a.handleSessionExpired()
+				return
+			}
+			if w := a.status(); w != nil {
+				w.SetState(t.To)
+			}
+		},
+		OnPosition: func(pos xplane.Position) {
+			if w := a.status(); w != nil {
+				w.UpdatePosition(pos)
+			}
+		},
+		OnSent: func(at time.Time, attempts int) {
+			if w := a.status(); w != nil {
+				w.SetLastSent(at, attempts)
+			}
+		},
+	})
+
+	// Listen for X-Plane on the network, to point the way when it can't be
+	// reached where we're looking
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if cfg.Simulator != "flightgear" {
+		go a.watchBeacons(ctx)
+	}
 
 	// Check if we have saved credentials
 	if cfg.HasCredentials() {
-		a.bushtalkClient.SetToken(cfg.ApiToken)
+		idToken, refreshToken, expiresAt := cfg.SavedSession()
+		a.bushtalkClient.SetSession(bushtalk.Session{
+			IDToken:      idToken,
+			RefreshToken: refreshToken,
+			ExpiresAt:    expiresAt,
+		})
 		a.showStatusWindow()
-		a.startTracking()
+		a.tracker.Start()
 	} else {
-		a.showLoginWindow()
+		a.showLoginWindow("")
 	}
 
 	a.fyneApp.Run()
 }
 
-func (a *App) showLoginWindow() {
-	a.loginWindow = ui.NewLoginWindow(a.fyneApp, a.cfg, a.bushtalkClient, func(token string) {
+// showLoginWindow shows the login form, with an optional message explaining why
+func (a *App) showLoginWindow(message string) {
+	if a.loginWindow != nil {
+		a.loginWindow.Reset(message)
+		a.loginWindow.Show()
+		return
+	}
+
+	a.loginWindow = ui.NewLoginWindow(a.fyneApp, a.cfg, a.bushtalkClient, func(session bushtalk.Session) {
 		// Login successful
-		a.bushtalkClient.SetToken(token)
+		a.bushtalkClient.SetSession(session)
 		a.loginWindow.Hide()
 		a.showStatusWindow()
-		a.startTracking()
+		a.tracker.Start()
 	})
 	a.loginWindow.Window().SetOnClosed(func() {
 		a.fyneApp.Quit()
 	})
+	a.loginWindow.Reset(message)
 	a.loginWindow.Show()
 }
 
 func (a *App) showStatusWindow() {
-	a.statusWindow = ui.NewStatusWindow(a.fyneApp,
-		// onDisconnect - stop tracking but stay logged in
-		func() {
-			a.stopTracking()
-			if a.xplaneClient != nil {
-				a.xplaneClient.Disconnect()
-			}
-			// Restart tracking (will reconnect to X-Plane)
-			a.startTracking()
-		},
-	)
-	a.statusWindow.Window().SetOnClosed(func() {
-		a.stopTracking()
-		if a.xplaneClient != nil {
-			a.xplaneClient.Disconnect()
-		}
+	w := ui.NewStatusWindow(a.fyneApp, a.cfg.SimulatorName(), a.tracker.Stop, a.tracker.Start)
+	w.Window().SetOnClosed(func() {
+		a.tracker.Stop()
 		a.fyneApp.Quit()
 	})
-	a.statusWindow.Show()
-}
-
-func (a *App) startTracking() {
-	a.stopCh = make(chan struct{})
-
-	// Connect to X-Plane
-	go a.connectXPlane()
 
-	// Start position sending loop
-	go a.trackingLoop()
+	// Under beaconsMu so watchBeacons can't update the list in between
+	a.beaconsMu.Lock()
+	w.SetDiscovered(a.beacons)
+	a.setStatus(w)
+	a.beaconsMu.Unlock()
+	w.Show()
 }
 
-func (a *App) stopTracking() {
-	if a.stopCh != nil {
-		close(a.stopCh)
-	}
+// status returns the status window, or nil while logged out
+func (a *App) status() *ui.StatusWindow {
+	a.windowMu.Lock()
+	defer a.windowMu.Unlock()
+	return a.statusWindow
 }
 
-func (a *App) connectXPlane() {
-	for {
-		select {
-		case <-a.stopCh:
-			return
-		default:
-		}
-
-		a.xplaneClient = xplane.NewClient(a.cfg.XPlanePort)
-		a.xplaneClient.SetCallbacks(
-			func() {
-				// Connected
-				if a.statusWindow != nil {
-					a.statusWindow.SetXPlaneConnected(true)
-				}
-			},
-			func() {
-				// Disconnected - will trigger reconnect
-				if a.statusWindow != nil {
-					a.statusWindow.SetXPlaneConnected(false)
-				}
-			},
-		)
-
-		err := a.xplaneClient.Connect()
-		if err != nil {
-			log.Printf("X-Plane connection failed: %v, retrying in %v", err, reconnectDelay)
-			select {
-			case <-a.stopCh:
-				return
-			case <-time.After(reconnectDelay):
-				continue
-			}
-		}
-
-		// Wait for disconnect or stop
-		select {
-		case <-a.stopCh:
-			a.xplaneClient.Disconnect()
-			return
-		case <-a.xplaneClient.Done():
-			// X-Plane disconnected, reconnect after delay
-			log.Printf("X-Plane disconnected, reconnecting in %v", reconnectDelay)
-			select {
-			case <-a.stopCh:
-				return
-			case <-time.After(reconnectDelay):
-				continue
-			}
-		}
-	}
+// setStatus replaces the status window, returning the previous one
+func (a *App) setStatus(w *ui.StatusWindow) *ui.StatusWindow {
+	a.windowMu.Lock()
+	defer a.windowMu.Unlock()
+	old := a.statusWindow
+	a.statusWindow = w
+	return old
 }
 
-func (a *App) trackingLoop() {
-	ticker := time.NewTicker(trackInterval)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-a.stopCh:
-			return
-		case <-ticker.C:
-			a.sendPosition()
+// watchBeacons keeps the status window's list of X-Plane instances current
+func (a *App) watchBeacons(ctx context.Context) {
+	err := xplane.WatchBeacons(ctx, func(beacons []xplane.Beacon) {
+		a.beaconsMu.Lock()
+		defer a.beaconsMu.Unlock()
+		a.beacons = beacons
+		if w := a.status(); w != nil {
+			w.SetDiscovered(beacons)
 		}
-	}
-}
-
-func (a *App) sendPosition() {
-	if a.xplaneClient == nil || !a.xplaneClient.IsConnected() {
-		return
-	}
-
-	pos := a.xplaneClient.GetPosition()
-	if !pos.IsValid() {
-		return
-	}
-
-	// Update status window
-	if a.statusWindow != nil {
-		a.statusWindow.UpdatePosition(pos)
-	}
-
-	// Convert to Bushtalk format
-	payload := &bushtalk.TrackPayload{
-		Latitude:       pos.Latitude,
-		Longitude:      pos.Longitude,
-		AltitudeAGL:    pos.AltitudeAGL * 3.28084, // meters to feet
-		GroundVelocity: pos.Groundspeed * 1.94384, // m/s to knots
-		Heading:        pos.Heading,
-		TailNumber:     pos.TailNumber,
-		OnGround:       pos.AltitudeAGL < 1.0, // Below 1 meter AGL
-	}
-
-	log.Printf("Sending: lat=%.4f lon=%.4f alt=%.0fft spd=%.0fkts hdg=%.0f° tail=%s ground=%v",
-		payload.Latitude, payload.Longitude, payload.AltitudeAGL,
-		payload.GroundVelocity, payload.Heading, payload.TailNumber, payload.OnGround)
-
-	err := a.bushtalkClient.SendPosition(payload)
+	})
 	if err != nil {
-		log.Printf("Failed to send position: %v", err)
-		return
+		log.Printf("Not listening for X-Plane on the network: %v", err)
 	}
+}
 
-	if a.statusWindow != nil {
-		a.statusWindow.SetLastSent(time.Now())
+// handleSessionExpired brings the login window back once the tracker has
+// logged out and forgotten the rejected tokens
+func (a *App) handleSessionExpired() {
+	if w := a.setStatus(nil); w != nil {
+		// Replace the quit handler so closing the window doesn't exit the app
+		w.Window().SetOnClosed(func() {})
+		w.Close()
 	}
+	a.showLoginWindow("Your session has expired. Please log in again.")
 }
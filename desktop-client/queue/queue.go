@@ -0,0 +1,197 @@
+package queue
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/bushtalkradio/xplane-client/bushtalk"
+)
+
+// DefaultMaxEntries bounds the queue to 12 hours of points at one every 5 seconds
+const DefaultMaxEntries = 8640
+
+// compactSlack is how many lines the file may hold beyond twice the queued
+// points before it is rewritten
+const compactSlack = 100
+
+// removePrefix starts a line recording that the oldest points were removed
+const removePrefix = "remove "
+
+// Queue is a bounded FIFO of track points waiting to be sent, persisted so it
+// survives restarts. When the queue is full the oldest point is evicted to
+// make room for the newest.
+//
+// The file is a journal: each point is a JSON object on its own line, and
+// Remove appends a "remove N" line rather than rewriting the file. Evicted
+// points are dropped when the file is read back. The file is compacted once
+// it holds about twice as many lines as there are points queued.
+type Queue struct {
+	path       string
+	maxEntries int
+	entries    []*bushtalk.TrackPayload
+	lines      int // lines in the file
+	mu         sync.Mutex
+}
+
+// Open loads the queue stored at path, creating it if it doesn't exist.
+// An empty path gives an in-memory queue.
+func Open(path string, maxEntries int) (*Queue, error) {
+	if maxEntries <= 0 {
+		maxEntries = DefaultMaxEntries
+	}
+	q := &Queue{
+		path:       path,
+		maxEntries: maxEntries,
+	}
+	if path == "" {
+		return q, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 4096), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		if rest, ok := bytes.CutPrefix(line, []byte(removePrefix)); ok {
+			n, err := strconv.Atoi(string(rest))
+			if err != nil || n < 0 {
+				log.Printf("Skipping corrupt queue entry: %q", line)
+				continue
+			}
+			q.entries = q.entries[min(n, len(q.entries)):]
+			continue
+		}
+		var payload bushtalk.TrackPayload
+		if err := json.Unmarshal(line, &payload); err != nil {
+			// A partially written last line after a crash; skip it
+			log.Printf("Skipping corrupt queue entry: %v", err)
+			continue
+		}
+		q.entries = append(q.entries, &payload)
+		if len(q.entries) > q.maxEntries {
+			q.entries = q.entries[1:]
+		}
+	}
+
+	// Start from a clean file, without a partly written last line that the
+	// next append would run into
+	return q, q.rewrite()
+}
+
+// Len returns the number of queued points
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.entries)
+}
+
+// Push appends a point, evicting the oldest one if the queue is full
+func (q *Queue) Push(payload *bushtalk.TrackPayload) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	line, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	q.entries = append(q.entries, payload)
+	if len(q.entries) > q.maxEntries {
+		// Evicted again when the file is read back
+		q.entries = q.entries[1:]
+	}
+	return q.appendLine(line)
+}
+
+// Peek returns up to n of the oldest points without removing them
+func (q *Queue) Peek(n int) []*bushtalk.TrackPayload {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if n > len(q.entries) {
+		n = len(q.entries)
+	}
+	out := make([]*bushtalk.TrackPayload, n)
+	copy(out, q.entries[:n])
+	return out
+}
+
+// Remove drops the n oldest points, typically after they were sent
+func (q *Queue) Remove(n int) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if n > len(q.entries) {
+		n = len(q.entries)
+	}
+	if n <= 0 {
+		return nil
+	}
+	q.entries = q.entries[n:]
+	return q.appendLine([]byte(removePrefix + strconv.Itoa(n)))
+}
+
+// appendLine appends a line to the queue file, compacting the file instead
+// once it has grown well past the queued points
+func (q *Queue) appendLine(line []byte) error {
+	if q.path == "" {
+		return nil
+	}
+	if q.lines+1 > 2*len(q.entries)+compactSlack {
+		return q.rewrite()
+	}
+
+	f, err := os.OpenFile(q.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	q.lines++
+	return f.Close()
+}
+
+// rewrite atomically replaces the queue file with the current entries
+func (q *Queue) rewrite() error {
+	if q.path == "" {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	for _, payload := range q.entries {
+		line, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+
+	tmp := q.path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, q.path); err != nil {
+		return err
+	}
+	q.lines = len(q.entries)
+	return nil
+}
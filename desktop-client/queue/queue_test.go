@@ -0,0 +1,153 @@
+package queue_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/bushtalkradio/xplane-client/bushtalk"
+	"github.com/bushtalkradio/xplane-client/queue"
+)
+
+// point returns a payload told apart by its timestamp
+func point(i int) *bushtalk.TrackPayload {
+	return &bushtalk.TrackPayload{Latitude: 61.2, Longitude: -149.9, Timestamp: int64(i)}
+}
+
+// timestamps lists the queued points' timestamps, oldest first
+func timestamps(q *queue.Queue) []int64 {
+	var out []int64
+	for _, p := range q.Peek(q.Len()) {
+		out = append(out, p.Timestamp)
+	}
+	return out
+}
+
+func open(t *testing.T, path string, maxEntries int) *queue.Queue {
+	t.Helper()
+	q, err := queue.Open(path, maxEntries)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	return q
+}
+
+func push(t *testing.T, q *queue.Queue, from, to int) {
+	t.Helper()
+	for i := from; i <= to; i++ {
+		if err := q.Push(point(i)); err != nil {
+			t.Fatalf("Push(%d): %v", i, err)
+		}
+	}
+}
+
+func TestPushPastCapacity(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.jsonl")
+	q := open(t, path, 3)
+	push(t, q, 1, 5)
+
+	if got, want := timestamps(q), []int64{3, 4, 5}; !slices.Equal(got, want) {
+		t.Errorf("queued %v, want the newest %v", got, want)
+	}
+	if got, want := timestamps(open(t, path, 3)), []int64{3, 4, 5}; !slices.Equal(got, want) {
+		t.Errorf("reopened with %v, want %v", got, want)
+	}
+}
+
+func TestRemove(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.jsonl")
+	q := open(t, path, 10)
+	push(t, q, 1, 5)
+
+	if err := q.Remove(2); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	if got, want := timestamps(q), []int64{3, 4, 5}; !slices.Equal(got, want) {
+		t.Errorf("queued %v after Remove(2), want %v", got, want)
+	}
+	if err := q.Remove(10); err != nil {
+		t.Fatalf("Remove more than queued: %v", err)
+	}
+	if q.Len() != 0 {
+		t.Errorf("Len = %d after removing everything", q.Len())
+	}
+}
+
+func TestReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.jsonl")
+	q := open(t, path, 4)
+	push(t, q, 1, 3)
+	if err := q.Remove(1); err != nil {
+		t.Fatal(err)
+	}
+	push(t, q, 4, 6) // evicts 2
+	if err := q.Remove(1); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []int64{4, 5, 6}
+	if got := timestamps(q); !slices.Equal(got, want) {
+		t.Fatalf("queued %v, want %v", got, want)
+	}
+	if got := timestamps(open(t, path, 4)); !slices.Equal(got, want) {
+		t.Errorf("reopened with %v, want %v", got, want)
+	}
+}
+
+func TestTruncatedLastLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.jsonl")
+	q := open(t, path, 10)
+	push(t, q, 1, 2)
+
+	// A crash part way through writing the third point
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"PLANE_LATITUDE":61.2,"PLANE_LONG`)
+	f.Close()
+
+	q = open(t, path, 10)
+	if got, want := timestamps(q), []int64{1, 2}; !slices.Equal(got, want) {
+		t.Fatalf("queued %v, want %v without the partial point", got, want)
+	}
+	push(t, q, 3, 3)
+	if got, want := timestamps(open(t, path, 10)), []int64{1, 2, 3}; !slices.Equal(got, want) {
+		t.Errorf("reopened with %v, want %v", got, want)
+	}
+}
+
+func TestDrainCompactsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.jsonl")
+	q := open(t, path, 1000)
+	push(t, q, 1, 1000)
+	for q.Len() > 0 {
+		if err := q.Remove(1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := bytes.Count(data, []byte("\n")); lines > 200 {
+		t.Errorf("empty queue's file has %d lines, want it compacted", lines)
+	}
+	if n := open(t, path, 1000).Len(); n != 0 {
+		t.Errorf("reopened with %d points, want none", n)
+	}
+}
+
+func TestInMemory(t *testing.T) {
+	q := open(t, "", 2)
+	push(t, q, 1, 3)
+	if err := q.Remove(1); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := timestamps(q), []int64{3}; !slices.Equal(got, want) {
+		t.Errorf("queued %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,286 @@
+package replay
+
+import (
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bushtalkradio/xplane-client/sim"
+	"github.com/bushtalkradio/xplane-client/xplane"
+)
+
+// taxiSpeed is the groundspeed (m/s, about 30 knots) below which a flight
+// that doesn't say whether it's on the ground is assumed to be
+const taxiSpeed = 15.4
+
+// csvColumns maps CSV header names to the Position fields they set. Units
+// follow the names: feet, knots, feet per minute and degrees.
+var csvColumns = map[string]func(p *sim.Position, v string) error{
+	"latitude":           csvNumber(func(p *sim.Position, v float64) { p.Latitude = v }),
+	"longitude":          csvNumber(func(p *sim.Position, v float64) { p.Longitude = v }),
+	"altitude_msl_ft":    csvNumber(func(p *sim.Position, v float64) { p.AltitudeMSL = v * 0.3048 }),
+	"altitude_agl_ft":    csvNumber(func(p *sim.Position, v float64) { p.AltitudeAGL = v * 0.3048 }),
+	"groundspeed_kts":    csvNumber(func(p *sim.Position, v float64) { p.Groundspeed = v / 1.94384 }),
+	"ias_kts":            csvNumber(func(p *sim.Position, v float64) { p.IndicatedAirspeed = v }),
+	"vertical_speed_fpm": csvNumber(func(p *sim.Position, v float64) { p.VerticalSpeed = v }),
+	"heading":            csvNumber(func(p *sim.Position, v float64) { p.Heading = v }),
+	"true_heading":       csvNumber(func(p *sim.Position, v float64) { p.TrueHeading = v }),
+	"track":              csvNumber(func(p *sim.Position, v float64) { p.Track = v }),
+	"pitch":              csvNumber(func(p *sim.Position, v float64) { p.Pitch = v }),
+	"roll":               csvNumber(func(p *sim.Position, v float64) { p.Roll = v }),
+	"tail_number":        func(p *sim.Position, v string) error { p.TailNumber = v; return nil },
+	"aircraft_icao":      func(p *sim.Position, v string) error { p.AircraftICAO = v; return nil },
+	"on_ground": func(p *sim.Position, v string) error {
+		b, err := strconv.ParseBool(v)
+		p.OnGround, p.HasOnGround = b, true
+		return err
+	},
+}
+
+// csvFlightState are the columns a CSV needs for sim.Capabilities.FlightState
+var csvFlightState = []string{"altitude_msl_ft", "ias_kts", "vertical_speed_fpm", "true_heading", "track", "pitch", "roll"}
+
+func csvNumber(set func(p *sim.Position, v float64)) func(*sim.Position, string) error {
+	return func(p *sim.Position, v string) error {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return err
+		}
+		set(p, f)
+		return nil
+	}
+}
+
+// ReadCSV reads a flight from CSV with a header row. time, latitude and
+// longitude are required; time is seconds from the start of the flight or
+// an RFC 3339 timestamp. The other columns are listed in csvColumns, and
+// unknown ones are ignored.
+func ReadCSV(r io.Reader) (*Flight, error) {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+
+	timeCol := -1
+	cols := make([]string, len(header))
+	for i, name := range header {
+		cols[i] = strings.ToLower(strings.TrimSpace(name))
+		if cols[i] == "time" {
+			timeCol = i
+		}
+	}
+	if timeCol < 0 {
+		return nil, errors.New("no time column")
+	}
+	for _, name := range []string{"latitude", "longitude"} {
+		if !contains(cols, name) {
+			return nil, fmt.Errorf("no %s column", name)
+		}
+	}
+	hasGround := contains(cols, "on_ground") || contains(cols, "altitude_agl_ft")
+
+	flight := &Flight{Capabilities: sim.Capabilities{
+		OnGround:     contains(cols, "on_ground"),
+		AircraftType: contains(cols, "aircraft_icao"),
+		FlightState:  true,
+	}}
+	for _, name := range csvFlightState {
+		if !contains(cols, name) {
+			flight.Capabilities.FlightState = false
+		}
+	}
+
+	var frames []Frame
+	var start time.Time
+	for line := 2; ; line++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		at, abs, err := parseTime(record[timeCol])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: time: %w", line, err)
+		}
+		if !abs.IsZero() {
+			if start.IsZero() {
+				start = abs
+			}
+			at = abs.Sub(start)
+		}
+
+		var p sim.Position
+		for i, value := range record {
+			set, ok := csvColumns[cols[i]]
+			if !ok || strings.TrimSpace(value) == "" {
+				continue
+			}
+			if err := set(&p, strings.TrimSpace(value)); err != nil {
+				return nil, fmt.Errorf("line %d: %s: %w", line, cols[i], err)
+			}
+		}
+		if !hasGround {
+			guessOnGround(&p)
+		}
+		if len(frames) > 0 && at < frames[len(frames)-1].At {
+			return nil, fmt.Errorf("line %d: time goes backwards", line)
+		}
+		frames = append(frames, Frame{At: at, Position: p})
+	}
+	flight.Frames = frames
+	return flight, nil
+}
+
+// parseTime reads seconds from the start, or an absolute RFC 3339 time
+func parseTime(v string) (time.Duration, time.Time, error) {
+	v = strings.TrimSpace(v)
+	if secs, err := strconv.ParseFloat(v, 64); err == nil {
+		return time.Duration(secs * float64(time.Second)), time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	return 0, t, err
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// guessOnGround decides whether p is on the ground from its speed, for
+// recordings with no ground flag or height above ground. Leaving it to the
+// AGL fallback would put every point on the ground.
+func guessOnGround(p *sim.Position) {
+	p.OnGround, p.HasOnGround = p.Groundspeed < taxiSpeed, true
+}
+
+// gpxFile is the part of a GPX document we read
+type gpxFile struct {
+	Tracks []struct {
+		Segments []struct {
+			Points []struct {
+				Lat  float64   `xml:"lat,attr"`
+				Lon  float64   `xml:"lon,attr"`
+				Ele  float64   `xml:"ele"`
+				Time time.Time `xml:"time"`
+			} `xml:"trkpt"`
+		} `xml:"trkseg"`
+	} `xml:"trk"`
+}
+
+// ReadGPX reads a flight from a GPX track. Points need times. Elevation is
+// taken as MSL altitude, and groundspeed and track are worked out from
+// neighbouring points. GPX has no heading, so the track is used. Only the
+// basic position is reported; the rest of the flight state isn't there.
+func ReadGPX(r io.Reader) (*Flight, error) {
+	var doc gpxFile
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	var frames []Frame
+	var start time.Time
+	for _, trk := range doc.Tracks {
+		for _, seg := range trk.Segments {
+			for _, pt := range seg.Points {
+				if pt.Time.IsZero() {
+					return nil, errors.New("track point without a time")
+				}
+				if start.IsZero() {
+					start = pt.Time
+				}
+				at := pt.Time.Sub(start)
+				if len(frames) > 0 && at < frames[len(frames)-1].At {
+					return nil, fmt.Errorf("track point at %s: time goes backwards", pt.Time.Format(time.RFC3339))
+				}
+				frames = append(frames, Frame{
+					At: at,
+					Position: sim.Position{
+						Latitude:    pt.Lat,
+						Longitude:   pt.Lon,
+						AltitudeMSL: pt.Ele,
+					},
+				})
+			}
+		}
+	}
+
+	for i := range frames {
+		// Speed and direction over the leg ending here, or the first leg
+		a, b := i-1, i
+		if i == 0 {
+			a, b = 0, 1
+		}
+		p := &frames[i].Position
+		if b < len(frames) {
+			from, to := frames[a], frames[b]
+			dist, bearing := greatCircle(from.Position, to.Position)
+			if dt := (to.At - from.At).Seconds(); dt > 0 {
+				p.Groundspeed = dist / dt
+			}
+			if dist > 0 {
+				p.Track, p.TrueHeading, p.Heading = bearing, bearing, bearing
+			}
+		}
+		guessOnGround(p)
+	}
+	return &Flight{Frames: frames}, nil
+}
+
+// greatCircle returns the distance in meters and initial bearing in degrees
+// true from a to b
+func greatCircle(a, b sim.Position) (float64, float64) {
+	const earthRadius = 6371000.0
+	lat1, lat2 := a.Latitude*math.Pi/180, b.Latitude*math.Pi/180
+	dLat := lat2 - lat1
+	dLon := (b.Longitude - a.Longitude) * math.Pi / 180
+
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	dist := 2 * earthRadius * math.Asin(math.Sqrt(h))
+
+	y := math.Sin(dLon) * math.Cos(lat2)
+	x := math.Cos(lat1)*math.Sin(lat2) - math.Sin(lat1)*math.Cos(lat2)*math.Cos(dLon)
+	bearing := math.Mod(math.Atan2(y, x)*180/math.Pi+360, 360)
+	return dist, bearing
+}
+
+// ReadCapture reads a flight from an X-Plane WebSocket capture, with a
+// frame for every message X-Plane sent. The Web API reports everything.
+func ReadCapture(r io.Reader) (*Flight, error) {
+	var frames []Frame
+	var start time.Time
+	err := xplane.ReadCapture(r, nil, func(at time.Time, p xplane.Position) {
+		if start.IsZero() {
+			start = at
+		}
+		frames = append(frames, Frame{At: at.Sub(start), Position: p})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Flight{
+		Frames: frames,
+		Capabilities: sim.Capabilities{
+			OnGround:      true,
+			SimState:      true,
+			AircraftType:  true,
+			AircraftTitle: true,
+			Livery:        true,
+			FlightState:   true,
+		},
+	}, nil
+}
@@ -0,0 +1,208 @@
+package replay
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bushtalkradio/xplane-client/sim"
+)
+
+const flightCSV = `time,latitude,longitude,altitude_msl_ft,groundspeed_kts,heading,tail_number
+0,61.2176,-149.8997,150,0,90,N185BT
+30,61.2176,-149.8900,150,60,90,N185BT
+60,61.2176,-149.8800,1150,100,95,N185BT
+`
+
+func TestReadCSV(t *testing.T) {
+	flight, err := ReadCSV(strings.NewReader(flightCSV))
+	if err != nil {
+		t.Fatalf("ReadCSV: %v", err)
+	}
+	if len(flight.Frames) != 3 || flight.Frames[2].At != time.Minute {
+		t.Fatalf("frames = %+v", flight.Frames)
+	}
+	p := flight.Frames[2].Position
+	if p.Longitude != -149.88 || p.TailNumber != "N185BT" || p.Heading != 95 {
+		t.Errorf("position = %+v", p)
+	}
+	if math.Abs(p.AltitudeMSL-350.52) > 0.01 || math.Abs(p.Groundspeed*1.94384-100) > 0.01 {
+		t.Errorf("MSL = %v m, groundspeed = %v m/s", p.AltitudeMSL, p.Groundspeed)
+	}
+	if !flight.Frames[0].Position.IsOnGround() || p.IsOnGround() {
+		t.Error("on ground should follow groundspeed when the file doesn't say")
+	}
+	if caps := flight.Capabilities; caps.FlightState || caps.OnGround || caps.AircraftType {
+		t.Errorf("capabilities = %+v for a file without those columns", caps)
+	}
+
+	withFlag := "Time,Latitude,Longitude,On_Ground\n2024-06-01T12:00:00Z,61.2,-149.8,true\n2024-06-01T12:00:05Z,61.3,-149.8,false\n"
+	flight, err = ReadCSV(strings.NewReader(withFlag))
+	if err != nil {
+		t.Fatalf("ReadCSV with timestamps: %v", err)
+	}
+	if flight.Frames[1].At != 5*time.Second || !flight.Frames[0].Position.IsOnGround() || flight.Frames[1].Position.IsOnGround() {
+		t.Errorf("frames = %+v", flight.Frames)
+	}
+	if !flight.Capabilities.OnGround {
+		t.Error("OnGround capability not set with an on_ground column")
+	}
+
+	for name, bad := range map[string]string{
+		"no longitude":    "time,latitude\n0,61.2\n",
+		"bad number":      "time,latitude,longitude\n0,north,-149.8\n",
+		"time backwards":  "time,latitude,longitude\n5,61.2,-149.8\n1,61.2,-149.8\n",
+		"no time column":  "latitude,longitude\n61.2,-149.8\n",
+		"unreadable time": "time,latitude,longitude\nnoon,61.2,-149.8\n",
+	} {
+		if _, err := ReadCSV(strings.NewReader(bad)); err == nil {
+			t.Errorf("%s: ReadCSV accepted it", name)
+		}
+	}
+}
+
+const flightGPX = `<?xml version="1.0" encoding="UTF-8"?>
+<gpx version="1.1" creator="test" xmlns="http://www.topografix.com/GPX/1/1">
+  <trk><trkseg>
+    <trkpt lat="61.0" lon="-149.0"><ele>30</ele><time>2024-06-01T12:00:00Z</time></trkpt>
+    <trkpt lat="61.0" lon="-149.0"><ele>30</ele><time>2024-06-01T12:01:00Z</time></trkpt>
+    <trkpt lat="61.1" lon="-149.0"><ele>330</ele><time>2024-06-01T12:05:00Z</time></trkpt>
+  </trkseg></trk>
+</gpx>`
+
+func TestReadGPX(t *testing.T) {
+	flight, err := ReadGPX(strings.NewReader(flightGPX))
+	if err != nil {
+		t.Fatalf("ReadGPX: %v", err)
+	}
+	if len(flight.Frames) != 3 || flight.Frames[2].At != 5*time.Minute {
+		t.Fatalf("frames = %+v", flight.Frames)
+	}
+	if !flight.Frames[1].Position.IsOnGround() {
+		t.Error("not on ground while stationary")
+	}
+	p := flight.Frames[2].Position
+	// 0.1 degrees of latitude is about 11.1 km, flown in 4 minutes
+	if math.Abs(p.Groundspeed-11119.5/240) > 0.5 || p.AltitudeMSL != 330 {
+		t.Errorf("groundspeed = %v m/s, MSL = %v m", p.Groundspeed, p.AltitudeMSL)
+	}
+	if p.Track > 0.01 || p.Heading != p.Track || p.IsOnGround() {
+		t.Errorf("track = %v, heading = %v, on ground = %v", p.Track, p.Heading, p.IsOnGround())
+	}
+
+	if caps := flight.Capabilities; caps != (sim.Capabilities{}) {
+		t.Errorf("capabilities = %+v, GPX has none", caps)
+	}
+
+	noTime := `<gpx><trk><trkseg><trkpt lat="61" lon="-149"></trkpt></trkseg></trk></gpx>`
+	if _, err := ReadGPX(strings.NewReader(noTime)); err == nil {
+		t.Error("ReadGPX accepted a point without a time")
+	}
+
+	backwards := `<gpx><trk><trkseg>
+		<trkpt lat="61" lon="-149"><time>2024-06-01T12:01:00Z</time></trkpt>
+		<trkpt lat="61" lon="-149"><time>2024-06-01T12:00:00Z</time></trkpt>
+	</trkseg></trk></gpx>`
+	if _, err := ReadGPX(strings.NewReader(backwards)); err == nil {
+		t.Error("ReadGPX accepted a time going backwards")
+	}
+}
+
+func TestInterpolation(t *testing.T) {
+	flight, err := ReadCSV(strings.NewReader(flightCSV))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewSource(flight, 1)
+
+	mid := s.at(45 * time.Second)
+	if math.Abs(mid.Longitude+149.885) > 1e-9 || math.Abs(mid.AltitudeMSL-650*0.3048) > 1e-9 {
+		t.Errorf("halfway position = %+v", mid)
+	}
+	if mid.Heading != 90 {
+		t.Errorf("heading = %v, want the earlier frame's", mid.Heading)
+	}
+	if end := s.at(time.Hour); end.Longitude != -149.88 {
+		t.Errorf("position after the end = %+v, want the last frame", end)
+	}
+}
+
+func TestInterpolationAcrossAntimeridian(t *testing.T) {
+	s := NewSource(&Flight{Frames: []Frame{
+		{Position: sim.Position{Latitude: -17, Longitude: 179}},
+		{At: time.Minute, Position: sim.Position{Latitude: -17, Longitude: -179}},
+	}}, 1)
+
+	for _, tt := range []struct {
+		at   time.Duration
+		want float64
+	}{
+		{15 * time.Second, 179.5},
+		{45 * time.Second, -179.5},
+	} {
+		if got := s.at(tt.at).Longitude; math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("longitude at %v = %v, want %v", tt.at, got, tt.want)
+		}
+	}
+	if mid := s.at(30 * time.Second).Longitude; math.Abs(math.Abs(mid)-180) > 1e-9 {
+		t.Errorf("longitude halfway = %v, want 180", mid)
+	}
+}
+
+func TestSourcePlayback(t *testing.T) {
+	flight, err := ReadCSV(strings.NewReader(flightCSV))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewSource(flight, 30)
+	if s.Snapshot().IsValid() {
+		t.Error("position before ConnectContext")
+	}
+	if err := s.ConnectContext(context.Background()); err != nil {
+		t.Fatalf("ConnectContext: %v", err)
+	}
+	defer s.Disconnect()
+
+	// At 30x the flight is 2 seconds long
+	time.Sleep(1200 * time.Millisecond)
+	p := s.Snapshot()
+	if p.Longitude <= -149.89 || p.Longitude >= -149.88 {
+		t.Errorf("longitude after 36 flight seconds = %v", p.Longitude)
+	}
+	if p.TimeAcceleration() != 30 || time.Since(p.Timestamp) > time.Second {
+		t.Errorf("rate = %d, timestamp = %v", p.TimeAcceleration(), p.Timestamp)
+	}
+
+	s.Disconnect()
+	select {
+	case <-s.Done():
+	default:
+		t.Error("Done not closed after Disconnect")
+	}
+}
+
+func TestDialerPlaysOnce(t *testing.T) {
+	flight := &Flight{Frames: []Frame{{Position: sim.Position{Latitude: 61.2, Longitude: -149.9}}}}
+	dial := Dialer(flight, 1, false)
+	s, err := dial(context.Background())
+	if err != nil {
+		t.Fatalf("first dial: %v", err)
+	}
+	s.Disconnect()
+
+	if _, err := dial(context.Background()); !errors.Is(err, ErrFinished) {
+		t.Errorf("second dial = %v without -replay-loop, want ErrFinished", err)
+	}
+
+	loop := Dialer(flight, 1, true)
+	for i := 0; i < 2; i++ {
+		s, err := loop(context.Background())
+		if err != nil {
+			t.Fatalf("looping dial %d: %v", i, err)
+		}
+		s.Disconnect()
+	}
+}
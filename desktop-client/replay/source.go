@@ -0,0 +1,209 @@
+// Package replay plays back a recorded flight as if a simulator were
+// flying it, for demos and for reproducing bug reports without a simulator.
+// Flights can be loaded from CSV, GPX or an X-Plane WebSocket capture (see
+// xplane.Client.SetCapture).
+package replay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bushtalkradio/xplane-client/sim"
+)
+
+// ErrFinished is returned by a Dialer that isn't looping once its flight has
+// been played
+var ErrFinished = errors.New("replay finished")
+
+// finishHold is how long the last position is held after the flight ends,
+// so the tracker samples it before the source goes away
+const finishHold = 10 * time.Second
+
+// Frame is a recorded position, At after the start of the flight
+type Frame struct {
+	At       time.Duration
+	Position sim.Position
+}
+
+// Flight is a recorded flight. Capabilities says which parts of the frames'
+// positions the recording filled in.
+type Flight struct {
+	Frames       []Frame
+	Capabilities sim.Capabilities
+}
+
+// Load reads a flight, choosing the format from the file's extension:
+// .csv, .gpx, or .jsonl for a WebSocket capture
+func Load(path string) (*Flight, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var flight *Flight
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".csv":
+		flight, err = ReadCSV(f)
+	case ".gpx":
+		flight, err = ReadGPX(f)
+	case ".jsonl", ".json":
+		flight, err = ReadCapture(f)
+	default:
+		return nil, fmt.Errorf("unknown flight format %q; use .csv, .gpx or .jsonl", ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(flight.Frames) == 0 {
+		return nil, fmt.Errorf("%s: no positions", path)
+	}
+	return flight, nil
+}
+
+// Source plays a flight back in real time, or speed times faster. Positions
+// between frames are interpolated, and are timestamped when read, as a live
+// connection's would be.
+type Source struct {
+	frames []Frame
+	caps   sim.Capabilities
+	speed  float64
+
+	start    time.Time
+	stopOnce sync.Once
+	timer    *time.Timer
+	mu       sync.Mutex // guards start and timer
+	doneCh   chan struct{}
+}
+
+// NewSource creates a source playing flight at speed (1 for real time)
+func NewSource(flight *Flight, speed float64) *Source {
+	if speed <= 0 {
+		speed = 1
+	}
+	return &Source{
+		frames: flight.Frames,
+		caps:   flight.Capabilities,
+		speed:  speed,
+		doneCh: make(chan struct{}),
+	}
+}
+
+// Dialer returns a sim.Dialer that plays flight at speed. Unless loop is
+// set the flight is played once; later dials fail with ErrFinished.
+func Dialer(flight *Flight, speed float64, loop bool) sim.Dialer {
+	var played atomic.Bool
+	return func(ctx context.Context) (sim.FlightSource, error) {
+		if played.Swap(true) && !loop {
+			return nil, ErrFinished
+		}
+		s := NewSource(flight, speed)
+		if err := s.ConnectContext(ctx); err != nil {
+			return nil, err
+		}
+		return s, nil
+	}
+}
+
+// ConnectContext starts playback
+func (s *Source) ConnectContext(ctx context.Context) error {
+	if len(s.frames) == 0 {
+		return errors.New("no positions to replay")
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	length := time.Duration(float64(s.frames[len(s.frames)-1].At) / s.speed)
+	s.mu.Lock()
+	s.start = time.Now()
+	s.timer = time.AfterFunc(length+finishHold, s.Disconnect)
+	s.mu.Unlock()
+	return nil
+}
+
+// Done is closed when the flight has finished or Disconnect is called
+func (s *Source) Done() <-chan struct{} {
+	return s.doneCh
+}
+
+// Snapshot returns the position at the current point in the flight
+func (s *Source) Snapshot() sim.Position {
+	s.mu.Lock()
+	start := s.start
+	s.mu.Unlock()
+	if start.IsZero() {
+		return sim.Position{}
+	}
+
+	p := s.at(time.Duration(float64(time.Since(start)) * s.speed))
+	p.Timestamp = time.Now()
+	// A faster replay moves the aircraft faster than it really flew
+	if rate := int(math.Round(s.speed)); rate > 1 {
+		p.TimeCompression = max(p.TimeCompression, 1) * rate
+	}
+	return p
+}
+
+// at returns the position elapsed into the flight
+func (s *Source) at(elapsed time.Duration) sim.Position {
+	i := sort.Search(len(s.frames), func(i int) bool { return s.frames[i].At > elapsed })
+	if i == 0 {
+		return s.frames[0].Position
+	}
+	if i == len(s.frames) {
+		return s.frames[i-1].Position
+	}
+
+	prev, next := s.frames[i-1], s.frames[i]
+	p := prev.Position
+	if span := next.At - prev.At; span > 0 {
+		f := float64(elapsed-prev.At) / float64(span)
+		p.Latitude = lerp(prev.Position.Latitude, next.Position.Latitude, f)
+		p.Longitude = lerpLongitude(prev.Position.Longitude, next.Position.Longitude, f)
+		p.AltitudeAGL = lerp(prev.Position.AltitudeAGL, next.Position.AltitudeAGL, f)
+		p.AltitudeMSL = lerp(prev.Position.AltitudeMSL, next.Position.AltitudeMSL, f)
+	}
+	return p
+}
+
+func lerp(a, b, f float64) float64 {
+	return a + (b-a)*f
+}
+
+// lerpLongitude interpolates the short way round, which crosses the
+// antimeridian between 179 and -179
+func lerpLongitude(a, b, f float64) float64 {
+	d := math.Mod(b-a+540, 360) - 180
+	return math.Mod(a+d*f+540, 360) - 180
+}
+
+// Capabilities reports what the recording has
+func (s *Source) Capabilities() sim.Capabilities {
+	caps := s.caps
+	caps.Name = "Replay"
+	return caps
+}
+
+// Disconnect stops playback. It's safe to call more than once.
+func (s *Source) Disconnect() {
+	s.stopOnce.Do(func() {
+		s.mu.Lock()
+		if s.timer != nil {
+			s.timer.Stop()
+		}
+		s.mu.Unlock()
+		close(s.doneCh)
+	})
+}
+
+var _ sim.FlightSource = (*Source)(nil)
@@ -0,0 +1,174 @@
+package sim
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// DefaultReconnectDelay is how long Manager waits before reconnecting
+const DefaultReconnectDelay = 5 * time.Second
+
+// Snapshot is the state of a Manager's connection at one moment
+type Snapshot struct {
+	Connected    bool
+	Position     Position
+	Capabilities Capabilities // of the connected source
+	Err          error        // why the last connection attempt failed, if it did
+}
+
+// Manager keeps a connection to a simulator open, reconnecting whenever it
+// drops. Its Run goroutine is the only owner of the underlying FlightSource;
+// other goroutines see the connection through Snapshot and Updates.
+type Manager struct {
+	name           string // for logging, e.g. "X-Plane"
+	dial           Dialer
+	reconnectDelay time.Duration
+
+	requests chan chan Snapshot
+	updates  chan Snapshot
+	done     chan struct{}
+}
+
+// connectResult carries the outcome of a connection attempt back to Run
+type connectResult struct {
+	source FlightSource
+	err    error
+}
+
+// NewManager creates a manager that connects to the simulator called name
+// with dial. Call Run to start it.
+func NewManager(name string, dial Dialer) *Manager {
+	return &Manager{
+		name:           name,
+		dial:           dial,
+		reconnectDelay: DefaultReconnectDelay,
+		requests:       make(chan chan Snapshot),
+		updates:        make(chan Snapshot, 1),
+		done:           make(chan struct{}),
+	}
+}
+
+// Name returns the simulator's name
+func (m *Manager) Name() string {
+	return m.name
+}
+
+// SetReconnectDelay sets how long to wait between connection attempts. Call
+// before Run.
+func (m *Manager) SetReconnectDelay(d time.Duration) {
+	m.reconnectDelay = d
+}
+
+// Updates delivers a Snapshot whenever the connection is made or lost. Only
+// the latest is kept if the receiver falls behind.
+func (m *Manager) Updates() <-chan Snapshot {
+	return m.updates
+}
+
+// Done is closed once Run has returned and the connection is closed
+func (m *Manager) Done() <-chan struct{} {
+	return m.done
+}
+
+// Snapshot returns the current connection state and position. It returns a
+// disconnected Snapshot if ctx is cancelled or the manager has stopped.
+func (m *Manager) Snapshot(ctx context.Context) Snapshot {
+	reply := make(chan Snapshot, 1)
+	select {
+	case m.requests <- reply:
+		return <-reply
+	case <-ctx.Done():
+	case <-m.done:
+	}
+	return Snapshot{}
+}
+
+// Run connects to the simulator and keeps the connection up until ctx is
+// cancelled, then disconnects. Call it once.
+func (m *Manager) Run(ctx context.Context) {
+	defer close(m.done)
+
+	results := make(chan connectResult)
+	var source FlightSource        // owned by this goroutine
+	var sourceDone <-chan struct{} // nil (blocks) while not connected
+	var retry <-chan time.Time     // nil (blocks) unless waiting to reconnect
+
+	connecting := true
+	go m.connect(ctx, results)
+
+	for {
+		select {
+		case <-ctx.Done():
+			if connecting {
+				// Let the attempt finish so its source can't leak
+				if r := <-results; r.err == nil {
+					r.source.Disconnect()
+				}
+			}
+			if source != nil {
+				source.Disconnect()
+			}
+			return
+
+		case r := <-results:
+			connecting = false
+			if r.err != nil {
+				log.Printf("%s connection failed: %v, retrying in %v", m.name, r.err, m.reconnectDelay)
+				m.publish(Snapshot{Err: r.err})
+				retry = time.After(m.reconnectDelay)
+				continue
+			}
+			source, sourceDone = r.source, r.source.Done()
+			log.Printf("Connected to %s", source.Capabilities().Name)
+			m.publish(m.connected(source))
+
+		case <-sourceDone:
+			log.Printf("%s disconnected, reconnecting in %v", m.name, m.reconnectDelay)
+			source.Disconnect()
+			source, sourceDone = nil, nil
+			m.publish(Snapshot{})
+			retry = time.After(m.reconnectDelay)
+
+		case <-retry:
+			retry = nil
+			connecting = true
+			go m.connect(ctx, results)
+
+		case reply := <-m.requests:
+			if source == nil {
+				reply <- Snapshot{}
+				continue
+			}
+			select {
+			case <-sourceDone:
+				// Lost, and Run will notice on its next pass
+				reply <- Snapshot{}
+			default:
+				reply <- m.connected(source)
+			}
+		}
+	}
+}
+
+// connected describes a live connection
+func (m *Manager) connected(source FlightSource) Snapshot {
+	return Snapshot{Connected: true, Position: source.Snapshot(), Capabilities: source.Capabilities()}
+}
+
+// connect makes one connection attempt and reports it to Run, which always
+// receives the result
+func (m *Manager) connect(ctx context.Context, results chan<- connectResult) {
+	s, err := m.dial(ctx)
+	results <- connectResult{source: s, err: err}
+}
+
+// publish replaces any unread update with s. Only Run calls it, so the
+// send never blocks.
+func (m *Manager) publish(s Snapshot) {
+	select {
+	case <-m.updates:
+	default:
+	}
+	m.updates <- s
+}
@@ -0,0 +1,66 @@
+package sim
+
+import "time"
+
+// Position holds the current flight position data
+type Position struct {
+	Latitude    float64
+	Longitude   float64
+	AltitudeAGL float64 // meters
+	Groundspeed float64 // m/s
+	Heading     float64 // magnetic heading
+	TailNumber  string
+
+	// Aircraft identity; empty if the simulator doesn't say
+	AircraftICAO        string // type designator, e.g. C185
+	AircraftDescription string
+	AircraftAuthor      string
+	Livery              string // livery folder name; empty for the default paint
+
+	// Extended flight state
+	AltitudeMSL       float64 // meters
+	IndicatedAirspeed float64 // knots
+	VerticalSpeed     float64 // feet per minute
+	TrueHeading       float64 // degrees true
+	Track             float64 // ground track, degrees true
+	Pitch             float64 // degrees, positive nose up
+	Roll              float64 // degrees, positive right wing down
+
+	OnGround    bool // any part of the aircraft touching ground or water
+	HasOnGround bool // OnGround came from the simulator
+	Timestamp   time.Time
+
+	// Simulator state
+	Paused          bool
+	Replay          bool
+	SimSpeed        int // physics rate multiplier; 0 if unknown
+	TimeCompression int // ground speed multiplier; 0 if unknown
+}
+
+// IsValid returns true if we have received position data
+func (p Position) IsValid() bool {
+	return p.Latitude != 0 || p.Longitude != 0
+}
+
+// IsOnGround reports whether the aircraft is on the ground, falling back to
+// an AGL guess if the simulator didn't say. The guess is wrong for floatplanes and
+// tall gear, so only use it when we must.
+func (p Position) IsOnGround() bool {
+	if p.HasOnGround {
+		return p.OnGround
+	}
+	return p.AltitudeAGL < 1.0 // Below 1 meter AGL
+}
+
+// TimeAcceleration returns how many times faster than real time the
+// aircraft is moving, 1 when running normally
+func (p Position) TimeAcceleration() int {
+	rate := 1
+	if p.SimSpeed > 1 {
+		rate *= p.SimSpeed
+	}
+	if p.TimeCompression > 1 {
+		rate *= p.TimeCompression
+	}
+	return rate
+}
@@ -0,0 +1,35 @@
+// Package sim defines what the tracker needs from a flight simulator, so the
+// same tracking loop can follow X-Plane, FlightGear or a recorded flight.
+package sim
+
+import "context"
+
+// FlightSource is a live connection to a simulator delivering positions
+type FlightSource interface {
+	// ConnectContext connects and returns once positions are arriving, or
+	// fails. Once connected, call Disconnect to close.
+	ConnectContext(ctx context.Context) error
+	// Done is closed when the connection is lost
+	Done() <-chan struct{}
+	// Snapshot returns the latest position
+	Snapshot() Position
+	// Capabilities says which parts of Position the source fills in
+	Capabilities() Capabilities
+	// Disconnect closes the connection. It may be called more than once.
+	Disconnect()
+}
+
+// Capabilities describes what a FlightSource reports. Fields of Position it
+// doesn't report are left zero.
+type Capabilities struct {
+	Name          string // shown to the user, e.g. "X-Plane Web API"
+	OnGround      bool   // sets HasOnGround, rather than leaving it to an AGL guess
+	SimState      bool   // Paused, Replay and time acceleration
+	AircraftType  bool   // ICAO type designator
+	AircraftTitle bool   // description and author
+	Livery        bool   // livery name
+	FlightState   bool   // MSL altitude, airspeed, vertical speed, true heading, track and attitude
+}
+
+// Dialer makes one connection attempt
+type Dialer func(ctx context.Context) (FlightSource, error)
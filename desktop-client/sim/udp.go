@@ -0,0 +1,155 @@
+package sim
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// UDPListener is the connection lifecycle shared by sources that read a
+// simulator's UDP packets. Open publishes the socket, Await waits for the
+// simulator's first packet, and Serve reads the rest in the background until
+// Close is called or the simulator goes quiet. Close may be called from any
+// goroutine at any point, including while connecting.
+type UDPListener struct {
+	name string // for logging, e.g. "FlightGear"
+
+	conn        *net.UDPConn
+	connMu      sync.Mutex // guards conn against a concurrent Close
+	connected   bool
+	connectedMu sync.RWMutex
+	stopOnce    sync.Once
+	stopCh      chan struct{}
+	doneCh      chan struct{}
+}
+
+// NewUDPListener creates a listener for the simulator called name
+func NewUDPListener(name string) *UDPListener {
+	return &UDPListener{
+		name:   name,
+		stopCh: make(chan struct{}),
+		doneCh: make(chan struct{}),
+	}
+}
+
+// Open hands conn to the listener, so Close will close it. If Close has
+// already been called conn is closed and Open fails.
+func (l *UDPListener) Open(conn *net.UDPConn) error {
+	l.connMu.Lock()
+	defer l.connMu.Unlock()
+	select {
+	case <-l.stopCh:
+		conn.Close()
+		return errors.New("disconnected while connecting")
+	default:
+	}
+	l.conn = conn
+	return nil
+}
+
+// Await reads packets until accept takes one, waiting up to timeout or
+// until ctx is done. accept reports whether the packet came from the
+// simulator, or fails the connection. The socket is closed if Await fails.
+func (l *UDPListener) Await(ctx context.Context, timeout time.Duration, accept func(packet []byte) (bool, error)) error {
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	stop := context.AfterFunc(ctx, func() { l.conn.SetReadDeadline(time.Now()) })
+	defer stop()
+	l.conn.SetReadDeadline(deadline)
+	buf := make([]byte, 4096)
+	for {
+		n, _, err := l.conn.ReadFromUDP(buf)
+		if err != nil {
+			l.conn.Close()
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		ok, err := accept(buf[:n])
+		if err != nil {
+			l.conn.Close()
+			return err
+		}
+		if ok {
+			return nil
+		}
+	}
+}
+
+// Serve marks the listener connected and passes packets to handle in the
+// background until Close is called or nothing arrives for staleTimeout
+// (0 waits forever). Done is closed when it stops.
+func (l *UDPListener) Serve(staleTimeout time.Duration, handle func(packet []byte)) {
+	l.setConnected(true)
+	go l.readLoop(staleTimeout, handle)
+}
+
+func (l *UDPListener) readLoop(staleTimeout time.Duration, handle func(packet []byte)) {
+	defer func() {
+		l.setConnected(false)
+		close(l.doneCh)
+	}()
+
+	buf := make([]byte, 4096)
+	for {
+		if staleTimeout > 0 {
+			l.conn.SetReadDeadline(time.Now().Add(staleTimeout))
+		}
+		n, _, err := l.conn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-l.stopCh:
+			default:
+				var netErr net.Error
+				if errors.As(err, &netErr) && netErr.Timeout() {
+					log.Printf("Nothing from %s for %v, treating the connection as lost", l.name, staleTimeout)
+				} else {
+					log.Printf("%s UDP read error: %v", l.name, err)
+				}
+			}
+			return
+		}
+		handle(buf[:n])
+	}
+}
+
+// Done returns a channel that's closed once Serve has stopped
+func (l *UDPListener) Done() <-chan struct{} {
+	return l.doneCh
+}
+
+// IsConnected returns true while Serve is receiving packets
+func (l *UDPListener) IsConnected() bool {
+	l.connectedMu.RLock()
+	defer l.connectedMu.RUnlock()
+	return l.connected
+}
+
+func (l *UDPListener) setConnected(connected bool) {
+	l.connectedMu.Lock()
+	l.connected = connected
+	l.connectedMu.Unlock()
+}
+
+// Close stops the listener and closes the socket, first calling beforeClose
+// (if not nil) if a socket was opened. It's safe to call more than once.
+func (l *UDPListener) Close(beforeClose func()) {
+	l.stopOnce.Do(func() {
+		l.connMu.Lock()
+		close(l.stopCh)
+		if l.conn != nil {
+			if beforeClose != nil {
+				beforeClose()
+			}
+			l.conn.Close()
+		}
+		l.connMu.Unlock()
+	})
+	l.setConnected(false)
+}
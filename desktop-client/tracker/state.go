@@ -0,0 +1,81 @@
+package tracker
+
+import "time"
+
+// State is where the tracker is in its lifecycle
+type State int
+
+const (
+	// StateLoggedOut means there is no session; nothing is tracked
+	StateLoggedOut State = iota
+	// StateConnecting means the tracker is trying to reach the simulator
+	StateConnecting
+	// StateWaitingForSim means the simulator is connected but has no valid
+	// position yet, e.g. it's still in the menus
+	StateWaitingForSim
+	// StateTracking means positions are being captured and sent
+	StateTracking
+	// StatePaused means the user stopped tracking but is still logged in
+	StatePaused
+	// StateError means positions can't be sent; they are queued and retried
+	StateError
+	// StateSimPaused means the simulator is paused; positions aren't sent
+	StateSimPaused
+	// StateReplay means the simulator is showing a replay; positions aren't sent
+	StateReplay
+)
+
+func (s State) String() string {
+	switch s {
+	case StateLoggedOut:
+		return "LoggedOut"
+	case StateConnecting:
+		return "Connecting"
+	case StateWaitingForSim:
+		return "WaitingForSim"
+	case StateTracking:
+		return "Tracking"
+	case StatePaused:
+		return "Paused"
+	case StateError:
+		return "Error"
+	case StateSimPaused:
+		return "SimPaused"
+	case StateReplay:
+		return "Replay"
+	}
+	return "Unknown"
+}
+
+// transitions lists the states each state may move to. Anything else is a
+// stale event from a run that has since been stopped, and is ignored. A
+// position can arrive before the connection update, so Connecting may move
+// straight to the position states.
+var transitions = map[State][]State{
+	StateLoggedOut:     {StateConnecting},
+	StateConnecting:    {StateWaitingForSim, StateTracking, StateSimPaused, StateReplay, StatePaused, StateLoggedOut, StateError},
+	StateWaitingForSim: {StateTracking, StateSimPaused, StateReplay, StateConnecting, StatePaused, StateLoggedOut, StateError},
+	StateTracking:      {StateWaitingForSim, StateSimPaused, StateReplay, StateConnecting, StatePaused, StateLoggedOut, StateError},
+	StatePaused:        {StateConnecting, StateLoggedOut},
+	StateError:         {StateConnecting, StateWaitingForSim, StateTracking, StateSimPaused, StateReplay, StatePaused, StateLoggedOut},
+	StateSimPaused:     {StateTracking, StateWaitingForSim, StateReplay, StateConnecting, StatePaused, StateLoggedOut, StateError},
+	StateReplay:        {StateTracking, StateWaitingForSim, StateSimPaused, StateConnecting, StatePaused, StateLoggedOut, StateError},
+}
+
+// canTransition reports whether from may move to to
+func canTransition(from, to State) bool {
+	for _, s := range transitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Transition describes a state change
+type Transition struct {
+	From   State
+	To     State
+	Reason string
+	At     time.Time
+}
@@ -0,0 +1,41 @@
+package tracker
+
+import "testing"
+
+func TestCanTransition(t *testing.T) {
+	tests := []struct {
+		from, to State
+		want     bool
+	}{
+		{StateLoggedOut, StateConnecting, true},
+		{StateLoggedOut, StateTracking, false},
+		{StateConnecting, StateWaitingForSim, true},
+		{StateConnecting, StateTracking, true},
+		{StateWaitingForSim, StateTracking, true},
+		{StateTracking, StateConnecting, true},
+		{StateTracking, StatePaused, true},
+		// Late events from a stopped run must not resume it
+		{StatePaused, StateWaitingForSim, false},
+		{StatePaused, StateTracking, false},
+		{StatePaused, StateError, false},
+		{StatePaused, StateConnecting, true},
+		{StateError, StateTracking, true},
+		{StateTracking, StateSimPaused, true},
+		{StateReplay, StateTracking, true},
+		{StatePaused, StateReplay, false},
+	}
+
+	for _, tt := range tests {
+		if got := canTransition(tt.from, tt.to); got != tt.want {
+			t.Errorf("canTransition(%s, %s) = %v, want %v", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+func TestStateString(t *testing.T) {
+	for s := StateLoggedOut; s <= StateReplay; s++ {
+		if s.String() == "Unknown" {
+			t.Errorf("State(%d) has no name", s)
+		}
+	}
+}
@@ -0,0 +1,510 @@
+// Package tracker connects to the simulator, captures positions and uploads
+// them to Bushtalk Radio. It is shared by the desktop app and the headless CLI.
+package tracker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bushtalkradio/xplane-client/bushtalk"
+	"github.com/bushtalkradio/xplane-client/config"
+	"github.com/bushtalkradio/xplane-client/flightgear"
+	"github.com/bushtalkradio/xplane-client/queue"
+	"github.com/bushtalkradio/xplane-client/sim"
+	"github.com/bushtalkradio/xplane-client/xplane"
+)
+
+const (
+	trackInterval       = 5 * time.Second
+	uploadRetryInterval = 30 * time.Second
+	uploadBatchSize     = 100
+)
+
+// Callbacks receive tracker events. Any of them may be nil. They are called
+// from the tracker's goroutines; OnStateChange calls are made one at a time,
+// in order, and must not call Start or Stop.
+type Callbacks struct {
+	OnStateChange func(t Transition)
+	OnPosition    func(pos sim.Position)
+	OnSent        func(at time.Time, attempts int)
+}
+
+// Tracker runs the simulator connection, position capture and upload loops
+type Tracker struct {
+	cfg       *config.Config
+	client    *bushtalk.Client
+	queue     *queue.Queue
+	callbacks Callbacks
+	uploadCh  chan struct{}
+	simName   string     // set with dial to replace the configured simulator
+	dial      sim.Dialer // nil to use the configured simulator
+	capture   io.Writer  // X-Plane messages are recorded here if set
+
+	// resolver caches X-Plane's dataref IDs across runs; resolverAddr is the
+	// host and port it was made for
+	resolver     *xplane.Resolver
+	resolverAddr string
+
+	state      State
+	simState   State              // last simulator state, restored when an error clears
+	cancel     context.CancelFunc // cancels the current run; nil unless running
+	uploadDone chan struct{}      // closed when the last run's upload loop exits
+	mu         sync.Mutex         // guards state, simState, cancel, uploadDone and resolver
+	notifyMu   sync.Mutex         // keeps OnStateChange calls in order
+}
+
+// New creates a tracker that sends positions with client. Refreshed tokens
+// are saved to cfg if the user chose to remember their login.
+func New(cfg *config.Config, client *bushtalk.Client, q *queue.Queue) *Tracker {
+	t := &Tracker{
+		cfg:      cfg,
+		client:   client,
+		queue:    q,
+		uploadCh: make(chan struct{}, 1),
+	}
+	client.SetOnSessionRefresh(t.saveSession)
+	return t
+}
+
+// SetCallbacks sets the event callbacks. Call before Start.
+func (t *Tracker) SetCallbacks(callbacks Callbacks) {
+	t.callbacks = callbacks
+}
+
+// SetDialer replaces the configured simulator with dial, for example to
+// play back a recorded flight. Call before Start.
+func (t *Tracker) SetDialer(name string, dial sim.Dialer) {
+	t.simName = name
+	t.dial = dial
+}
+
+// SetCapture records the messages X-Plane's Web API sends to w, so the flight
+// can be replayed later. Call before Start.
+func (t *Tracker) SetCapture(w io.Writer) {
+	t.capture = w
+}
+
+// OpenQueue opens the on-disk queue of unsent positions, falling back to an
+// in-memory queue if the config directory isn't usable
+func OpenQueue() *queue.Queue {
+	path := ""
+	if dir, err := config.Dir(); err == nil {
+		path = filepath.Join(dir, "queue.jsonl")
+	}
+
+	q, err := queue.Open(path, queue.DefaultMaxEntries)
+	if err != nil {
+		log.Printf("Failed to open position queue, unsent positions won't survive a restart: %v", err)
+		q, _ = queue.Open("", queue.DefaultMaxEntries)
+	}
+	if n := q.Len(); n > 0 {
+		log.Printf("Loaded %d unsent positions", n)
+	}
+	return q
+}
+
+// saveSession persists refreshed tokens when the user chose "Remember me"
+func (t *Tracker) saveSession(session bushtalk.Session) {
+	if err := t.cfg.UpdateTokens(session.IDToken, session.RefreshToken, session.ExpiresAt); err != nil {
+		log.Printf("Failed to save refreshed session: %v", err)
+	}
+}
+
+// State returns the current state
+func (t *Tracker) State() State {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.state
+}
+
+// Queued returns how many positions are waiting to be sent
+func (t *Tracker) Queued() int {
+	return t.queue.Len()
+}
+
+// Start connects to the simulator and starts capturing and uploading positions.
+// It does nothing if tracking is already running.
+func (t *Tracker) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.mu.Lock()
+	if t.cancel != nil {
+		t.mu.Unlock()
+		cancel()
+		return
+	}
+	t.cancel = cancel
+	t.simState = StateConnecting
+	prevUploadDone := t.uploadDone
+	uploadDone := make(chan struct{})
+	t.uploadDone = uploadDone
+	t.mu.Unlock()
+
+	t.setState(nil, StateConnecting, "tracking started", false)
+
+	// Connect to the simulator; the manager owns the connection for this run
+	m := t.newManager()
+	go m.Run(ctx)
+	go t.watchSim(ctx, m)
+
+	// Start position capture and upload loops
+	go t.trackingLoop(ctx, m)
+	go func() {
+		defer close(uploadDone)
+		// A stopped run may still be finishing a request; never let two
+		// upload loops work on the queue at once
+		if prevUploadDone != nil {
+			<-prevUploadDone
+		}
+		t.uploadLoop(ctx)
+	}()
+}
+
+// newManager creates a manager for the configured simulator
+func (t *Tracker) newManager() *sim.Manager {
+	if t.dial != nil {
+		return sim.NewManager(t.simName, t.dial)
+	}
+	if t.cfg.Simulator == "flightgear" {
+		return sim.NewManager("FlightGear", flightgear.Dialer(t.cfg.FlightGearPort, t.cfg.StaleTimeout()))
+	}
+	xp := xplane.NewManager(t.cfg.XPlaneHost, t.cfg.XPlanePort)
+	xp.SetResolver(t.xplaneResolver())
+	xp.SetSource(t.cfg.XPlaneSource, t.cfg.XPlaneUDPPort)
+	xp.SetDebug(t.cfg.Debug)
+	xp.SetStaleTimeout(t.cfg.StaleTimeout())
+	xp.SetCapture(t.capture)
+	return xp.Manager
+}
+
+// xplaneResolver returns the dataref ID cache for the configured X-Plane,
+// replacing it if the host or port changed since the last run
+func (t *Tracker) xplaneResolver() *xplane.Resolver {
+	addr := net.JoinHostPort(t.cfg.XPlaneHost, strconv.Itoa(t.cfg.XPlanePort))
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.resolver == nil || t.resolverAddr != addr {
+		t.resolver = xplane.NewResolver(t.cfg.XPlaneHost, t.cfg.XPlanePort)
+		t.resolverAddr = addr
+	}
+	return t.resolver
+}
+
+// Stop stops tracking but stays logged in. Unsent positions stay queued.
+func (t *Tracker) Stop() {
+	if t.stop() {
+		t.setState(nil, StatePaused, "tracking stopped", false)
+	}
+}
+
+// stop cancels the tracking loops, aborting any in-flight requests. It
+// reports whether tracking was running.
+func (t *Tracker) stop() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.cancel == nil {
+		return false
+	}
+	t.cancel()
+	t.cancel = nil
+	return true
+}
+
+// setState moves to state to, logging the transition and notifying
+// OnStateChange. Events observed by a run (ctx) that has since been stopped
+// are ignored, as are transitions the state machine doesn't allow. Simulator
+// states (sim) are remembered while in StateError and restored once sending
+// works again.
+func (t *Tracker) setState(ctx context.Context, to State, reason string, sim bool) {
+	t.notifyMu.Lock()
+	defer t.notifyMu.Unlock()
+
+	t.mu.Lock()
+	if ctx != nil && ctx.Err() != nil {
+		t.mu.Unlock()
+		return
+	}
+	if sim {
+		t.simState = to
+		if t.state == StateError {
+			t.mu.Unlock()
+			return
+		}
+	}
+	from := t.state
+	if from == to || !canTransition(from, to) {
+		t.mu.Unlock()
+		return
+	}
+	t.state = to
+	t.mu.Unlock()
+
+	log.Printf("State: %s -> %s (%s)", from, to, reason)
+	if t.callbacks.OnStateChange != nil {
+		t.callbacks.OnStateChange(Transition{From: from, To: to, Reason: reason, At: time.Now()})
+	}
+}
+
+// clearError returns from StateError to the last simulator state
+func (t *Tracker) clearError(ctx context.Context) {
+	t.mu.Lock()
+	inError, simState := t.state == StateError, t.simState
+	t.mu.Unlock()
+	if inError {
+		t.setState(ctx, simState, "sending positions again", false)
+	}
+}
+
+// handleSessionExpired stops tracking and forgets the rejected tokens
+func (t *Tracker) handleSessionExpired() {
+	log.Printf("Session expired")
+	t.stop()
+
+	t.client.SetSession(bushtalk.Session{})
+	if err := t.cfg.ForgetTokens(); err != nil {
+		log.Printf("Failed to save config: %v", err)
+	}
+
+	t.setState(nil, StateLoggedOut, "session expired", false)
+}
+
+// watchSim follows the simulator connection state
+func (t *Tracker) watchSim(ctx context.Context, m *sim.Manager) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case snap := <-m.Updates():
+			if snap.Connected {
+				t.setState(ctx, StateWaitingForSim, "connected to "+snap.Capabilities.Name, true)
+			} else {
+				t.setState(ctx, StateConnecting, m.Name()+" not connected", true)
+			}
+		}
+	}
+}
+
+func (t *Tracker) trackingLoop(ctx context.Context, m *sim.Manager) {
+	ticker := time.NewTicker(trackInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			t.sendPosition(ctx, m)
+		}
+	}
+}
+
+// sendPosition captures the current position and queues it for upload
+func (t *Tracker) sendPosition(ctx context.Context, m *sim.Manager) {
+	snap := m.Snapshot(ctx)
+	name := m.Name()
+	if !snap.Connected {
+		return
+	}
+
+	pos := snap.Position
+	if !pos.IsValid() {
+		t.setState(ctx, StateWaitingForSim, "no valid position from "+name, true)
+		return
+	}
+	// The simulator may be frozen; don't put an old position on the map as new
+	if stale := t.cfg.StaleTimeout(); stale > 0 {
+		if age := time.Since(pos.Timestamp); age > stale {
+			t.setState(ctx, StateWaitingForSim, fmt.Sprintf("no updates from %s for %v", name, age.Round(time.Second)), true)
+			return
+		}
+	}
+	// Replays and a paused sim would put nonsense on the map
+	if pos.Replay {
+		t.setState(ctx, StateReplay, name+" is in replay mode", true)
+		return
+	}
+	if pos.Paused {
+		t.setState(ctx, StateSimPaused, name+" is paused", true)
+		return
+	}
+	t.setState(ctx, StateTracking, "receiving positions", true)
+
+	if t.callbacks.OnPosition != nil {
+		t.callbacks.OnPosition(pos)
+	}
+
+	// Convert to Bushtalk format
+	payload := &bushtalk.TrackPayload{
+		Latitude:       pos.Latitude,
+		Longitude:      pos.Longitude,
+		AltitudeAGL:    pos.AltitudeAGL * 3.28084, // meters to feet
+		GroundVelocity: pos.Groundspeed * 1.94384, // m/s to knots
+		Heading:        pos.Heading,
+		TailNumber:     pos.TailNumber,
+		OnGround:       pos.IsOnGround(),
+		Timestamp:      time.Now().UnixMilli(),
+	}
+	// Leave out what the source doesn't know rather than sending zeros
+	caps := snap.Capabilities
+	if caps.AircraftType {
+		payload.AircraftICAO = pos.AircraftICAO
+	}
+	if caps.AircraftTitle {
+		payload.AircraftTitle = pos.AircraftDescription
+		payload.AircraftAuthor = pos.AircraftAuthor
+	}
+	if caps.Livery {
+		payload.Livery = pos.Livery
+	}
+	if caps.FlightState {
+		payload.AltitudeMSL = ptr(pos.AltitudeMSL * 3.28084) // meters to feet
+		payload.IndicatedAirspeed = ptr(pos.IndicatedAirspeed)
+		payload.VerticalSpeed = ptr(pos.VerticalSpeed)
+		payload.TrueHeading = ptr(pos.TrueHeading)
+		payload.Track = ptr(pos.Track)
+		payload.Pitch = ptr(pos.Pitch)
+		payload.Bank = ptr(pos.Roll)
+	}
+	// Flag time-accelerated points so the map can tell a 4x hop from a jet
+	if rate := pos.TimeAcceleration(); rate > 1 {
+		payload.SimulationRate = rate
+	}
+
+	log.Printf("Queueing: lat=%.4f lon=%.4f alt=%.0fft spd=%.0fkts hdg=%.0f° tail=%s ground=%v rate=%dx",
+		payload.Latitude, payload.Longitude, payload.AltitudeAGL,
+		payload.GroundVelocity, payload.Heading, payload.TailNumber, payload.OnGround, pos.TimeAcceleration())
+
+	if err := t.queue.Push(payload); err != nil {
+		log.Printf("Failed to persist queued position: %v", err)
+	}
+
+	// Wake the upload loop without blocking if it's already busy
+	select {
+	case t.uploadCh <- struct{}{}:
+	default:
+	}
+}
+
+// ptr returns a pointer to v, for the payload's optional fields
+func ptr(v float64) *float64 {
+	return &v
+}
+
+// uploadLoop sends queued positions whenever new ones arrive, and retries
+// the backlog periodically while the API is unreachable
+func (t *Tracker) uploadLoop(ctx context.Context) {
+	ticker := time.NewTicker(uploadRetryInterval)
+	defer ticker.Stop()
+
+	var pausedUntil time.Time // honours Retry-After
+	for {
+		if time.Now().After(pausedUntil) {
+			pausedUntil = t.flushQueue(ctx)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.uploadCh:
+		case <-ticker.C:
+		}
+	}
+}
+
+// flushQueue sends queued positions oldest first until the queue is empty
+// or the API stops accepting them. It returns when the server asked us to
+// try again, if it did.
+func (t *Tracker) flushQueue(ctx context.Context) (retryAt time.Time) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		batch := t.queue.Peek(uploadBatchSize)
+		if len(batch) == 0 {
+			return
+		}
+
+		sent, err := t.client.SendPositionsContext(ctx, batch)
+		t.markSent(ctx, sent)
+		if t.rejected(ctx, err) {
+			// The API turns a batch down whole, so send the rest one at a
+			// time and drop only the points it rejects
+			err = t.sendEach(ctx, batch[sent:])
+		}
+
+		if err != nil {
+			if ctx.Err() != nil {
+				// Tracking stopped; keep the batch for next time
+				return
+			}
+			log.Printf("Failed to send position: %v (%d queued)", err, t.queue.Len())
+			if errors.Is(err, bushtalk.ErrUnauthorized) {
+				t.handleSessionExpired()
+				return
+			}
+			t.setState(ctx, StateError, err.Error(), false)
+			if wait := bushtalk.RetryAfter(err); wait > 0 {
+				log.Printf("Server asked us to wait %v before sending again", wait)
+				retryAt = time.Now().Add(wait)
+			}
+			return
+		}
+	}
+}
+
+// sendEach sends points, the oldest queued, one at a time. Points the API
+// rejects are dropped, since sending them again won't help. It stops at the
+// first other error.
+func (t *Tracker) sendEach(ctx context.Context, points []*bushtalk.TrackPayload) error {
+	for _, p := range points {
+		err := t.client.SendPositionContext(ctx, p)
+		if err != nil && !t.rejected(ctx, err) {
+			return err
+		}
+		if err != nil {
+			log.Printf("Dropping rejected position: %v", err)
+			if err := t.queue.Remove(1); err != nil {
+				log.Printf("Failed to persist queue: %v", err)
+			}
+			continue
+		}
+		t.markSent(ctx, 1)
+	}
+	return nil
+}
+
+// rejected reports whether err means the API refused the points themselves,
+// which it says with a 400 or 422. Any other failure, such as a 403 or a
+// wrong api_url, is no reason to drop them.
+func (t *Tracker) rejected(ctx context.Context, err error) bool {
+	var apiErr *bushtalk.APIError
+	if ctx.Err() != nil || errors.Is(err, bushtalk.ErrUnauthorized) || !errors.As(err, &apiErr) {
+		return false
+	}
+	return apiErr.StatusCode == http.StatusBadRequest || apiErr.StatusCode == http.StatusUnprocessableEntity
+}
+
+// markSent removes the n oldest points from the queue once the API has them
+func (t *Tracker) markSent(ctx context.Context, n int) {
+	if n == 0 {
+		return
+	}
+	if err := t.queue.Remove(n); err != nil {
+		log.Printf("Failed to persist queue: %v", err)
+	}
+	if t.callbacks.OnSent != nil {
+		t.callbacks.OnSent(time.Now(), t.client.Stats().LastAttempts)
+	}
+	t.clearError(ctx)
+}
@@ -0,0 +1,219 @@
+package tracker
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/bushtalkradio/xplane-client/bushtalk"
+	"github.com/bushtalkradio/xplane-client/bushtalk/bushtalktest"
+	"github.com/bushtalkradio/xplane-client/config"
+	"github.com/bushtalkradio/xplane-client/queue"
+	"github.com/bushtalkradio/xplane-client/sim"
+	"github.com/bushtalkradio/xplane-client/xplane"
+	"github.com/bushtalkradio/xplane-client/xplane/xplanetest"
+)
+
+// newTracker returns a tracker wired to fake X-Plane and Bushtalk servers,
+// a channel of the states it enters, and the fake X-Plane
+func newTracker(t *testing.T) (*Tracker, <-chan State, *xplanetest.Server) {
+	t.Helper()
+
+	sim := xplanetest.NewServer()
+	t.Cleanup(sim.Close)
+	sim.AddDataref(xplane.DatarefLatitude, "double", 61.2176)
+	sim.AddDataref(xplane.DatarefLongitude, "double", -149.8997)
+
+	api := bushtalktest.NewServer()
+	t.Cleanup(api.Close)
+
+	cfg := config.DefaultConfig()
+	cfg.ApiURL = api.URL
+	cfg.XPlanePort = sim.Port()
+
+	q, err := queue.Open("", queue.DefaultMaxEntries)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	states := make(chan State, 100)
+	tr := New(cfg, bushtalk.NewClient(cfg.ApiURL), q)
+	tr.SetCallbacks(Callbacks{
+		OnStateChange: func(tn Transition) { states <- tn.To },
+	})
+	return tr, states, sim
+}
+
+// waitForState waits until the tracker enters want
+func waitForState(t *testing.T, states <-chan State, want State) {
+	t.Helper()
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case s := <-states:
+			if s == want {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for %s", want)
+		}
+	}
+}
+
+func TestStartStop(t *testing.T) {
+	tr, states, _ := newTracker(t)
+
+	tr.Start()
+	waitForState(t, states, StateConnecting)
+	waitForState(t, states, StateWaitingForSim)
+
+	tr.Stop()
+	waitForState(t, states, StatePaused)
+	if got := tr.State(); got != StatePaused {
+		t.Errorf("State() = %s after Stop, want Paused", got)
+	}
+
+	// Stopping again is harmless
+	tr.Stop()
+	if got := tr.State(); got != StatePaused {
+		t.Errorf("State() = %s after second Stop, want Paused", got)
+	}
+}
+
+// Restarting quickly must not leave a stale run changing state; run with -race
+func TestRestartIgnoresStaleRun(t *testing.T) {
+	tr, states, _ := newTracker(t)
+
+	for i := 0; i < 5; i++ {
+		tr.Start()
+		tr.Stop()
+	}
+	waitForState(t, states, StatePaused)
+
+	time.Sleep(100 * time.Millisecond)
+	if got := tr.State(); got != StatePaused {
+		t.Errorf("State() = %s after restarts, want Paused", got)
+	}
+}
+
+func TestRestartReusesResolvedIDs(t *testing.T) {
+	tr, states, sim := newTracker(t)
+
+	for i := 0; i < 2; i++ {
+		tr.Start()
+		waitForState(t, states, StateWaitingForSim)
+		tr.Stop()
+		waitForState(t, states, StatePaused)
+	}
+	if n := sim.Lookups(); n != 1 {
+		t.Errorf("Lookups() = %d after restarting tracking, want 1", n)
+	}
+}
+
+func TestFlushQueueDropsOnlyRejectedPoints(t *testing.T) {
+	api := bushtalktest.NewServer()
+	t.Cleanup(api.Close)
+	api.RejectPositions(func(p bushtalk.TrackPayload) bool { return p.Latitude > 90 })
+
+	q, err := queue.Open("", queue.DefaultMaxEntries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, lat := range []float64{61.1, 61.2, 99, 61.3, 61.4} {
+		q.Push(&bushtalk.TrackPayload{Latitude: lat, Longitude: -149.9})
+	}
+
+	client := bushtalk.NewClient(api.URL)
+	client.SetToken(api.IssueToken("pilot"))
+	tr := New(config.DefaultConfig(), client, q)
+	tr.flushQueue(context.Background())
+
+	got := api.Positions()
+	if len(got) != 4 {
+		t.Fatalf("server received %d positions, want the 4 valid ones: %+v", len(got), got)
+	}
+	for i, lat := range []float64{61.1, 61.2, 61.3, 61.4} {
+		if got[i].Latitude != lat {
+			t.Errorf("position %d latitude = %v, want %v", i, got[i].Latitude, lat)
+		}
+	}
+	if n := q.Len(); n != 0 {
+		t.Errorf("%d points left queued", n)
+	}
+}
+
+func TestFlushQueueKeepsPointsOnOtherErrors(t *testing.T) {
+	for _, status := range []int{http.StatusForbidden, http.StatusNotFound} {
+		api := bushtalktest.NewServer()
+		t.Cleanup(api.Close)
+		api.FailNext(status, 100, 0)
+
+		q, err := queue.Open("", queue.DefaultMaxEntries)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, lat := range []float64{61.1, 61.2, 61.3, 61.4, 61.5} {
+			q.Push(&bushtalk.TrackPayload{Latitude: lat, Longitude: -149.9})
+		}
+
+		client := bushtalk.NewClient(api.URL)
+		client.SetToken(api.IssueToken("pilot"))
+		tr := New(config.DefaultConfig(), client, q)
+		tr.flushQueue(context.Background())
+
+		if n := q.Len(); n != 5 {
+			t.Errorf("status %d: %d points left queued, want all 5 kept", status, n)
+		}
+	}
+}
+
+// fakeSource is a connected simulator reporting pos and caps
+type fakeSource struct {
+	pos  sim.Position
+	caps sim.Capabilities
+	done chan struct{}
+}
+
+func (f *fakeSource) ConnectContext(ctx context.Context) error { return nil }
+func (f *fakeSource) Done() <-chan struct{}                    { return f.done }
+func (f *fakeSource) Snapshot() sim.Position                   { return f.pos }
+func (f *fakeSource) Capabilities() sim.Capabilities           { return f.caps }
+func (f *fakeSource) Disconnect()                              {}
+
+func TestPayloadLeavesOutWhatSourceLacks(t *testing.T) {
+	source := &fakeSource{
+		pos: sim.Position{
+			Latitude:            61.2176,
+			Longitude:           -149.8997,
+			AircraftDescription: "Cessna C172P Skyhawk",
+			Timestamp:           time.Now(),
+		},
+		caps: sim.Capabilities{Name: "Test", AircraftTitle: true},
+		done: make(chan struct{}),
+	}
+	m := sim.NewManager("Test", func(ctx context.Context) (sim.FlightSource, error) { return source, nil })
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go m.Run(ctx)
+	<-m.Updates()
+
+	q, err := queue.Open("", queue.DefaultMaxEntries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := New(config.DefaultConfig(), bushtalk.NewClient(""), q)
+	tr.sendPosition(ctx, m)
+
+	queued := q.Peek(1)
+	if len(queued) != 1 {
+		t.Fatalf("%d positions queued, want 1", len(queued))
+	}
+	p := queued[0]
+	if p.AltitudeMSL != nil || p.IndicatedAirspeed != nil || p.Pitch != nil || p.Bank != nil {
+		t.Errorf("flight state sent for a source without it: %+v", p)
+	}
+	if p.AircraftTitle != "Cessna C172P Skyhawk" {
+		t.Errorf("AircraftTitle = %q", p.AircraftTitle)
+	}
+}
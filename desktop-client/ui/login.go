@@ -20,12 +20,15 @@ type LoginWindow struct {
 	window    fyne.Window
 	cfg       *config.Config
 	client    *bushtalk.Client
-	onSuccess func(token string)
+	onSuccess func(session bushtalk.Session)
 
 	usernameEntry *widget.Entry
 	passwordEntry *widget.Entry
 	rememberCheck *widget.Check
+	simSelect     *widget.Select
+	hostEntry     *widget.Entry
 	portEntry     *widget.Entry
+	fgPortEntry   *widget.Entry
 	apiURLEntry   *widget.Entry
 	consoleCheck  *widget.Check
 	loginButton   *widget.Button
@@ -33,7 +36,7 @@ type LoginWindow struct {
 }
 
 // NewLoginWindow creates a new login window
-func NewLoginWindow(app fyne.App, cfg *config.Config, client *bushtalk.Client, onSuccess func(token string)) *LoginWindow {
+func NewLoginWindow(app fyne.App, cfg *config.Config, client *bushtalk.Client, onSuccess func(session bushtalk.Session)) *LoginWindow {
 	l := &LoginWindow{
 		window:    app.NewWindow("Bushtalk Radio"),
 		cfg:       cfg,
@@ -95,10 +98,39 @@ func (l *LoginWindow) buildUI() {
 	))
 
 	// Advanced settings (collapsed by default)
+	l.hostEntry = widget.NewEntry()
+	l.hostEntry.SetPlaceHolder("localhost")
+	l.hostEntry.SetText(l.cfg.XPlaneHost)
+	l.hostEntry.Validator = config.ValidateHost
+
 	l.portEntry = widget.NewEntry()
 	l.portEntry.SetPlaceHolder("8086")
 	l.portEntry.SetText(fmt.Sprintf("%d", l.cfg.XPlanePort))
 
+	// FlightGear sends to us, so there's no host to set
+	l.fgPortEntry = widget.NewEntry()
+	l.fgPortEntry.SetPlaceHolder("5505")
+	l.fgPortEntry.SetText(fmt.Sprintf("%d", l.cfg.FlightGearPort))
+
+	// Only the selected simulator's settings are shown
+	xplaneForm := widget.NewForm(
+		widget.NewFormItem("X-Plane Host", l.hostEntry),
+		widget.NewFormItem("X-Plane Port", l.portEntry),
+	)
+	flightgearForm := widget.NewForm(
+		widget.NewFormItem("FlightGear Port", l.fgPortEntry),
+	)
+	l.simSelect = widget.NewSelect([]string{"X-Plane", "FlightGear"}, func(sim string) {
+		if sim == "FlightGear" {
+			xplaneForm.Hide()
+			flightgearForm.Show()
+		} else {
+			flightgearForm.Hide()
+			xplaneForm.Show()
+		}
+	})
+	l.simSelect.SetSelected(l.cfg.SimulatorName())
+
 	l.apiURLEntry = widget.NewEntry()
 	l.apiURLEntry.SetPlaceHolder("https://bushtalkradio.com")
 	l.apiURLEntry.SetText(l.cfg.ApiURL)
@@ -107,10 +139,10 @@ func (l *LoginWindow) buildUI() {
 	l.consoleCheck.SetChecked(l.cfg.ShowConsole)
 
 	advancedContent := container.NewVBox(
-		widget.NewForm(
-			widget.NewFormItem("X-Plane Port", l.portEntry),
-			widget.NewFormItem("API URL", l.apiURLEntry),
-		),
+		widget.NewForm(widget.NewFormItem("Simulator", l.simSelect)),
+		xplaneForm,
+		flightgearForm,
+		widget.NewForm(widget.NewFormItem("API URL", l.apiURLEntry)),
 		l.consoleCheck,
 	)
 
@@ -148,13 +180,29 @@ func (l *LoginWindow) handleLogin() {
 		return
 	}
 
-	// Parse and update port
-	var port int
-	if _, err := fmt.Sscanf(l.portEntry.Text, "%d", &port); err != nil || port <= 0 {
-		l.statusLabel.SetText("Invalid X-Plane port")
-		return
+	if l.simSelect.Selected == "FlightGear" {
+		port, ok := parsePort(l.fgPortEntry.Text)
+		if !ok {
+			l.statusLabel.SetText("Invalid FlightGear port")
+			return
+		}
+		l.cfg.Simulator = "flightgear"
+		l.cfg.FlightGearPort = port
+	} else {
+		// X-Plane may be on another computer
+		if err := config.ValidateHost(l.hostEntry.Text); err != nil {
+			l.statusLabel.SetText("Invalid X-Plane host: " + err.Error())
+			return
+		}
+		port, ok := parsePort(l.portEntry.Text)
+		if !ok {
+			l.statusLabel.SetText("Invalid X-Plane port")
+			return
+		}
+		l.cfg.Simulator = "xplane"
+		l.cfg.XPlaneHost = l.hostEntry.Text
+		l.cfg.XPlanePort = port
 	}
-	l.cfg.XPlanePort = port
 	l.cfg.ApiURL = l.apiURLEntry.Text
 	l.cfg.ShowConsole = l.consoleCheck.Checked
 
@@ -165,7 +213,7 @@ func (l *LoginWindow) handleLogin() {
 	l.statusLabel.SetText("Connecting...")
 
 	go func() {
-		authResp, err := l.client.Authenticate(username, password)
+		_, err := l.client.Authenticate(username, password)
 
 		if err != nil {
 			l.loginButton.Enable()
@@ -173,19 +221,26 @@ func (l *LoginWindow) handleLogin() {
 			return
 		}
 
+		session := l.client.GetSession()
+
 		// Save credentials if remember is checked
 		if l.rememberCheck.Checked {
-			l.cfg.Username = username
-			l.cfg.ApiToken = authResp.IDToken
-			if err := l.cfg.Save(); err != nil {
+			if err := l.cfg.SaveLogin(username, session.IDToken, session.RefreshToken, session.ExpiresAt); err != nil {
 				dialog.ShowError(err, l.window)
 			}
 		}
 
-		l.onSuccess(authResp.IDToken)
+		l.onSuccess(session)
 	}()
 }
 
+// Reset clears the password and re-enables the form, showing message in the status label
+func (l *LoginWindow) Reset(message string) {
+	l.passwordEntry.SetText("")
+	l.loginButton.Enable()
+	l.statusLabel.SetText(message)
+}
+
 // Show displays the login window
 func (l *LoginWindow) Show() {
 	l.window.Show()
@@ -205,3 +260,12 @@ func (l *LoginWindow) Close() {
 func (l *LoginWindow) Window() fyne.Window {
 	return l.window
 }
+
+// parsePort reads a port number typed into an entry
+func parsePort(text string) (int, bool) {
+	var port int
+	if _, err := fmt.Sscanf(text, "%d", &port); err != nil || port <= 0 || port > 65535 {
+		return 0, false
+	}
+	return port, true
+}
@@ -3,6 +3,9 @@ package ui
 import (
 	"fmt"
 	"image/color"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"fyne.io/fyne/v2"
@@ -12,6 +15,7 @@ import (
 	"fyne.io/fyne/v2/theme"
 	"fyne.io/fyne/v2/widget"
 
+	"github.com/bushtalkradio/xplane-client/tracker"
 	"github.com/bushtalkradio/xplane-client/xplane"
 )
 
@@ -23,18 +27,31 @@ type InfoRow struct {
 
 // StatusWindow shows connection status and position info
 type StatusWindow struct {
-	window       fyne.Window
-	onDisconnect func()
+	window    fyne.Window
+	simulator string // e.g. "X-Plane"
+	onStop    func()
+	onStart   func()
+	paused    atomic.Bool // set by the tracker, read by the tracking button
+
+	// X-Plane instances heard on the network, listed while connecting. Set
+	// from the tracker and beacon goroutines.
+	connecting   bool
+	beacons      []xplane.Beacon
+	discoveredMu sync.Mutex
 
 	connectionDot *canvas.Circle
-	xplaneStatus  *widget.Label
+	simStatus     *widget.Label
+	discovered    *widget.Label
 	tailRow       *InfoRow
+	aircraftInfo  *widget.Label
 	positionRow   *InfoRow
 	altitudeRow   *InfoRow
 	speedRow      *InfoRow
 	headingRow    *InfoRow
+	vsRow         *InfoRow
+	attitudeRow   *InfoRow
 	lastSentRow   *InfoRow
-	disconnectBtn *widget.Button
+	trackingBtn   *widget.Button
 
 	stopUpdate chan struct{}
 }
@@ -44,14 +61,18 @@ var (
 	colorConnected    = color.NRGBA{R: 52, G: 211, B: 153, A: 255}  // green
 	colorDisconnected = color.NRGBA{R: 248, G: 113, B: 113, A: 255} // red
 	colorPending      = color.NRGBA{R: 251, G: 146, B: 60, A: 255}  // orange
+	colorPaused       = color.NRGBA{R: 156, G: 163, B: 175, A: 255} // grey
 )
 
-// NewStatusWindow creates a new status window
-func NewStatusWindow(app fyne.App, onDisconnect func()) *StatusWindow {
+// NewStatusWindow creates a new status window for tracking simulator. The
+// tracking button calls onStop, or onStart once tracking has been stopped.
+func NewStatusWindow(app fyne.App, simulator string, onStop, onStart func()) *StatusWindow {
 	s := &StatusWindow{
-		window:       app.NewWindow("Bushtalk Radio"),
-		onDisconnect: onDisconnect,
-		stopUpdate:   make(chan struct{}),
+		window:     app.NewWindow("Bushtalk Radio"),
+		simulator:  simulator,
+		onStop:     onStop,
+		onStart:    onStart,
+		stopUpdate: make(chan struct{}),
 	}
 	s.buildUI()
 	return s
@@ -71,8 +92,8 @@ func (s *StatusWindow) buildUI() {
 	s.connectionDot = canvas.NewCircle(colorPending)
 	s.connectionDot.StrokeWidth = 0
 
-	s.xplaneStatus = widget.NewLabel("Connecting to X-Plane...")
-	s.xplaneStatus.TextStyle = fyne.TextStyle{Italic: true}
+	s.simStatus = widget.NewLabel("Connecting to " + s.simulator + "...")
+	s.simStatus.TextStyle = fyne.TextStyle{Italic: true}
 
 	// Fixed size dot container using a spacer rectangle
 	dotSpacer := canvas.NewRectangle(color.Transparent)
@@ -80,7 +101,7 @@ func (s *StatusWindow) buildUI() {
 
 	statusRow := container.NewHBox(
 		container.NewStack(dotSpacer, container.NewCenter(s.connectionDot)),
-		s.xplaneStatus,
+		s.simStatus,
 	)
 
 	header := container.NewHBox(
@@ -91,29 +112,46 @@ func (s *StatusWindow) buildUI() {
 		),
 	)
 
+	s.discovered = widget.NewLabel("")
+	s.discovered.Wrapping = fyne.TextWrapWord
+	s.discovered.Hide()
+
 	// Aircraft info card
 	s.tailRow = createInfoRow("Aircraft", "--")
+	s.aircraftInfo = widget.NewLabel("")
+	s.aircraftInfo.TextStyle = fyne.TextStyle{Italic: true}
+	s.aircraftInfo.Wrapping = fyne.TextWrapWord
+	s.aircraftInfo.Hide()
 	s.positionRow = createInfoRow("Position", "--")
 	s.altitudeRow = createInfoRow("Altitude", "--")
 	s.speedRow = createInfoRow("Speed", "--")
 	s.headingRow = createInfoRow("Heading", "--")
+	s.vsRow = createInfoRow("Vertical Speed", "--")
+	s.attitudeRow = createInfoRow("Attitude", "--")
 	s.lastSentRow = createInfoRow("Last Update", "--")
 
 	flightCard := widget.NewCard("Flight Data", "", container.NewVBox(
 		s.tailRow.Container,
+		s.aircraftInfo,
 		widget.NewSeparator(),
 		s.positionRow.Container,
 		s.altitudeRow.Container,
 		s.speedRow.Container,
 		s.headingRow.Container,
+		s.vsRow.Container,
+		s.attitudeRow.Container,
 		widget.NewSeparator(),
 		s.lastSentRow.Container,
 	))
 
 	// Buttons
-	s.disconnectBtn = widget.NewButtonWithIcon("Disconnect", theme.MediaStopIcon(), func() {
-		if s.onDisconnect != nil {
-			s.onDisconnect()
+	s.trackingBtn = widget.NewButtonWithIcon("Stop Tracking", theme.MediaStopIcon(), func() {
+		if s.paused.Load() {
+			if s.onStart != nil {
+				s.onStart()
+			}
+		} else if s.onStop != nil {
+			s.onStop()
 		}
 	})
 
@@ -126,18 +164,19 @@ func (s *StatusWindow) buildUI() {
 	// Main layout
 	content := container.NewVBox(
 		header,
+		s.discovered,
 		widget.NewSeparator(),
 		flightCard,
 		layout.NewSpacer(),
 		audioNote,
 		discordNote,
 		layout.NewSpacer(),
-		s.disconnectBtn,
+		s.trackingBtn,
 	)
 
 	padded := container.NewPadded(content)
 	s.window.SetContent(padded)
-	s.window.Resize(fyne.NewSize(360, 450))
+	s.window.Resize(fyne.NewSize(360, 520))
 	s.window.CenterOnScreen()
 }
 
@@ -160,44 +199,140 @@ func createInfoRow(label, value string) *InfoRow {
 	}
 }
 
-// SetXPlaneConnected updates the X-Plane connection status
-func (s *StatusWindow) SetXPlaneConnected(connected bool) {
-	if connected {
+// SetState shows the tracker state and offers to stop or restart tracking
+func (s *StatusWindow) SetState(state tracker.State) {
+	switch state {
+	case tracker.StateConnecting:
+		s.connectionDot.FillColor = colorPending
+		s.simStatus.SetText("Connecting to " + s.simulator + "...")
+	case tracker.StateWaitingForSim:
+		s.connectionDot.FillColor = colorPending
+		s.simStatus.SetText("Connected, waiting for flight")
+	case tracker.StateTracking:
 		s.connectionDot.FillColor = colorConnected
-		s.xplaneStatus.SetText("Connected to X-Plane")
-	} else {
+		s.simStatus.SetText("Tracking")
+	case tracker.StateSimPaused:
+		s.connectionDot.FillColor = colorPending
+		s.simStatus.SetText("Paused")
+	case tracker.StateReplay:
+		s.connectionDot.FillColor = colorPending
+		s.simStatus.SetText("Replay")
+	case tracker.StatePaused:
+		s.connectionDot.FillColor = colorPaused
+		s.simStatus.SetText("Tracking stopped")
+	case tracker.StateError:
+		s.connectionDot.FillColor = colorDisconnected
+		s.simStatus.SetText("Can't reach Bushtalk Radio, retrying")
+	case tracker.StateLoggedOut:
 		s.connectionDot.FillColor = colorDisconnected
-		s.xplaneStatus.SetText("Disconnected from X-Plane")
+		s.simStatus.SetText("Logged out")
 	}
 	s.connectionDot.Refresh()
+
+	s.discoveredMu.Lock()
+	s.connecting = state == tracker.StateConnecting
+	s.updateDiscovered()
+	s.discoveredMu.Unlock()
+
+	paused := state == tracker.StatePaused
+	s.paused.Store(paused)
+	if paused {
+		s.trackingBtn.SetText("Start Tracking")
+		s.trackingBtn.SetIcon(theme.MediaPlayIcon())
+	} else {
+		s.trackingBtn.SetText("Stop Tracking")
+		s.trackingBtn.SetIcon(theme.MediaStopIcon())
+	}
+}
+
+// SetDiscovered lists the X-Plane instances announcing themselves on the
+// network, shown while the companion can't connect
+func (s *StatusWindow) SetDiscovered(beacons []xplane.Beacon) {
+	s.discoveredMu.Lock()
+	defer s.discoveredMu.Unlock()
+	s.beacons = beacons
+	s.updateDiscovered()
+}
+
+// updateDiscovered shows or hides the list; call with discoveredMu held
+func (s *StatusWindow) updateDiscovered() {
+	if !s.connecting || len(s.beacons) == 0 {
+		s.discovered.Hide()
+		return
+	}
+	lines := []string{"X-Plane found on the network (set xplane_host in config.json to use it):"}
+	for _, b := range s.beacons {
+		lines = append(lines, b.String())
+	}
+	s.discovered.SetText(strings.Join(lines, "\n"))
+	s.discovered.Show()
 }
 
 // UpdatePosition updates the displayed position info
 func (s *StatusWindow) UpdatePosition(pos xplane.Position) {
-	// Update tail number
+	// Update tail number and type
+	var aircraft []string
 	if pos.TailNumber != "" && pos.TailNumber != "UNKNOWN" {
-		s.tailRow.Value.SetText(pos.TailNumber)
+		aircraft = append(aircraft, pos.TailNumber)
+	}
+	if pos.AircraftICAO != "" {
+		aircraft = append(aircraft, pos.AircraftICAO)
+	}
+	if len(aircraft) > 0 {
+		s.tailRow.Value.SetText(strings.Join(aircraft, " · "))
 	} else {
 		s.tailRow.Value.SetText("--")
 	}
 
+	// Description, livery and author underneath
+	var details []string
+	if pos.AircraftDescription != "" {
+		details = append(details, pos.AircraftDescription)
+	}
+	if pos.Livery != "" {
+		details = append(details, pos.Livery+" livery")
+	}
+	if pos.AircraftAuthor != "" {
+		details = append(details, "by "+pos.AircraftAuthor)
+	}
+	info := strings.Join(details, ", ")
+	s.aircraftInfo.SetText(info)
+	if info != "" {
+		s.aircraftInfo.Show()
+	} else {
+		s.aircraftInfo.Hide()
+	}
+
 	// Update position
 	if pos.IsValid() {
 		s.positionRow.Value.SetText(fmt.Sprintf("%.4f°, %.4f°", pos.Latitude, pos.Longitude))
-		s.altitudeRow.Value.SetText(fmt.Sprintf("%.0f ft AGL", pos.AltitudeAGL*3.28084))
-		s.speedRow.Value.SetText(fmt.Sprintf("%.0f kts", pos.Groundspeed*1.94384))
-		s.headingRow.Value.SetText(fmt.Sprintf("%.0f°", pos.Heading))
+		s.altitudeRow.Value.SetText(fmt.Sprintf("%.0f ft MSL · %.0f ft AGL", pos.AltitudeMSL*3.28084, pos.AltitudeAGL*3.28084))
+		speed := fmt.Sprintf("%.0f kts GS · %.0f kts IAS", pos.Groundspeed*1.94384, pos.IndicatedAirspeed)
+		if rate := pos.TimeAcceleration(); rate > 1 {
+			speed += fmt.Sprintf(" (%dx)", rate)
+		}
+		s.speedRow.Value.SetText(speed)
+		s.headingRow.Value.SetText(fmt.Sprintf("%03.0f°M · %03.0f°T · track %03.0f°T", pos.Heading, pos.TrueHeading, pos.Track))
+		s.vsRow.Value.SetText(fmt.Sprintf("%+.0f fpm", pos.VerticalSpeed))
+		s.attitudeRow.Value.SetText(fmt.Sprintf("pitch %+.0f° · bank %+.0f°", pos.Pitch, pos.Roll))
 	} else {
 		s.positionRow.Value.SetText("--")
 		s.altitudeRow.Value.SetText("--")
 		s.speedRow.Value.SetText("--")
 		s.headingRow.Value.SetText("--")
+		s.vsRow.Value.SetText("--")
+		s.attitudeRow.Value.SetText("--")
 	}
 }
 
-// SetLastSent updates the last sent timestamp
-func (s *StatusWindow) SetLastSent(t time.Time) {
-	s.lastSentRow.Value.SetText(t.Format("15:04:05"))
+// SetLastSent updates the last sent timestamp, noting retries if the
+// request needed more than one attempt
+func (s *StatusWindow) SetLastSent(t time.Time, attempts int) {
+	text := t.Format("15:04:05")
+	if attempts > 1 {
+		text += fmt.Sprintf(" (%d attempts)", attempts)
+	}
+	s.lastSentRow.Value.SetText(text)
 }
 
 // Show displays the status window
@@ -0,0 +1,200 @@
+package xplane
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"time"
+)
+
+// BeaconAddress is the multicast group every X-Plane on the network
+// announces itself to, about once a second
+const BeaconAddress = "239.255.1.1:49707"
+
+// beaconExpiry is how long an instance is listed after its last beacon
+const beaconExpiry = 5 * time.Second
+
+// Beacon roles
+const (
+	RoleMaster         = 1
+	RoleExternalVisual = 2
+	RoleIOS            = 3
+)
+
+// Beacon is an X-Plane instance heard on the network
+type Beacon struct {
+	Host     string // address the beacon came from
+	Hostname string // computer name X-Plane reports
+	Version  int    // e.g. 121101 for 12.11r1
+	Role     int    // RoleMaster, RoleExternalVisual or RoleIOS
+	UDPPort  int    // X-Plane's UDP port, normally 49000
+	LastSeen time.Time
+}
+
+// VersionString formats Version the way X-Plane shows it, e.g. "12.11r1"
+func (b Beacon) VersionString() string {
+	v := fmt.Sprintf("%d.%02d", b.Version/10000, b.Version/100%100)
+	if r := b.Version % 100; r > 0 {
+		v += fmt.Sprintf("r%d", r)
+	}
+	return v
+}
+
+// RoleString describes Role
+func (b Beacon) RoleString() string {
+	switch b.Role {
+	case RoleMaster:
+		return "master"
+	case RoleExternalVisual:
+		return "external visual"
+	case RoleIOS:
+		return "IOS"
+	}
+	return fmt.Sprintf("role %d", b.Role)
+}
+
+// String describes the instance, e.g. "SIMRIG (192.168.1.20), X-Plane 12.11r1, master"
+func (b Beacon) String() string {
+	return fmt.Sprintf("%s (%s), X-Plane %s, %s", b.Hostname, b.Host, b.VersionString(), b.RoleString())
+}
+
+// ParseBeacon decodes a BECN packet received from addr. The layout is
+// "BECN\0", major and minor beacon version bytes, then little-endian
+// application ID (1 for X-Plane), version and role as int32, the UDP port as
+// uint16 and a null-terminated computer name.
+func ParseBeacon(packet []byte, addr *net.UDPAddr) (Beacon, error) {
+	const header = 5 + 1 + 1 + 4 + 4 + 4 + 2
+	if len(packet) < header || !bytes.Equal(packet[:5], []byte("BECN\x00")) {
+		return Beacon{}, errors.New("not a BECN packet")
+	}
+	if major := packet[5]; major != 1 {
+		return Beacon{}, fmt.Errorf("unsupported beacon version %d", major)
+	}
+	if app := binary.LittleEndian.Uint32(packet[7:]); app != 1 {
+		return Beacon{}, fmt.Errorf("beacon from application %d, not X-Plane", app)
+	}
+
+	name := packet[header:]
+	if i := bytes.IndexByte(name, 0); i >= 0 {
+		name = name[:i]
+	}
+	b := Beacon{
+		Hostname: string(name),
+		Version:  int(int32(binary.LittleEndian.Uint32(packet[11:]))),
+		Role:     int(binary.LittleEndian.Uint32(packet[15:])),
+		UDPPort:  int(binary.LittleEndian.Uint16(packet[19:])),
+		LastSeen: time.Now(),
+	}
+	if addr != nil {
+		b.Host = addr.IP.String()
+	}
+	return b, nil
+}
+
+// ListenBeacons calls fn with every beacon heard until ctx is cancelled
+func ListenBeacons(ctx context.Context, fn func(Beacon)) error {
+	group, err := net.ResolveUDPAddr("udp4", BeaconAddress)
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenMulticastUDP("udp4", nil, group)
+	if err != nil {
+		return fmt.Errorf("failed to join the X-Plane beacon group: %w", err)
+	}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	defer conn.Close()
+
+	buf := make([]byte, 1024)
+	for {
+		n, addr, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		if b, err := ParseBeacon(buf[:n], addr); err == nil {
+			fn(b)
+		}
+	}
+}
+
+// WatchBeacons calls fn with the instances currently announcing themselves
+// whenever one appears or goes quiet, until ctx is cancelled. fn is called
+// from the listening goroutine.
+func WatchBeacons(ctx context.Context, fn func([]Beacon)) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	heard := make(chan Beacon)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- ListenBeacons(ctx, func(b Beacon) {
+			select {
+			case heard <- b:
+			case <-ctx.Done():
+			}
+		})
+	}()
+
+	seen := make(map[string]Beacon)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		changed := false
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-errCh:
+			return err
+		case b := <-heard:
+			key := beaconKey(b)
+			_, known := seen[key]
+			seen[key] = b
+			changed = !known
+		case now := <-ticker.C:
+			for key, b := range seen {
+				if now.Sub(b.LastSeen) > beaconExpiry {
+					delete(seen, key)
+					changed = true
+				}
+			}
+		}
+		if changed {
+			fn(sortBeacons(seen))
+		}
+	}
+}
+
+// Discover listens for wait and returns the instances heard
+func Discover(ctx context.Context, wait time.Duration) ([]Beacon, error) {
+	ctx, cancel := context.WithTimeout(ctx, wait)
+	defer cancel()
+
+	seen := make(map[string]Beacon)
+	err := ListenBeacons(ctx, func(b Beacon) {
+		seen[beaconKey(b)] = b
+	})
+	return sortBeacons(seen), err
+}
+
+// beaconKey identifies an instance; several can run on one computer
+func beaconKey(b Beacon) string {
+	return net.JoinHostPort(b.Host, fmt.Sprint(b.UDPPort))
+}
+
+func sortBeacons(seen map[string]Beacon) []Beacon {
+	beacons := make([]Beacon, 0, len(seen))
+	for _, b := range seen {
+		beacons = append(beacons, b)
+	}
+	sort.Slice(beacons, func(i, j int) bool {
+		return beaconKey(beacons[i]) < beaconKey(beacons[j])
+	})
+	return beacons
+}
@@ -0,0 +1,83 @@
+package xplane_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/bushtalkradio/xplane-client/xplane"
+	"github.com/bushtalkradio/xplane-client/xplane/xplanetest"
+)
+
+func TestParseBeacon(t *testing.T) {
+	addr := &net.UDPAddr{IP: net.IPv4(192, 168, 1, 20), Port: 49707}
+	b, err := xplane.ParseBeacon(xplanetest.BeaconPacket("SIMRIG", 121101, xplane.RoleMaster, 49000), addr)
+	if err != nil {
+		t.Fatalf("ParseBeacon: %v", err)
+	}
+	if b.Host != "192.168.1.20" || b.Hostname != "SIMRIG" || b.UDPPort != 49000 {
+		t.Errorf("beacon = %+v", b)
+	}
+	if got, want := b.String(), "SIMRIG (192.168.1.20), X-Plane 12.11r1, master"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+
+	bad := [][]byte{
+		nil,
+		[]byte("RREF,\x00\x00\x00\x00"),
+		xplanetest.BeaconPacket("SIMRIG", 121101, xplane.RoleMaster, 49000)[:12],
+	}
+	for _, packet := range bad {
+		if _, err := xplane.ParseBeacon(packet, addr); err == nil {
+			t.Errorf("ParseBeacon(%q) succeeded", packet)
+		}
+	}
+}
+
+func TestBeaconVersionString(t *testing.T) {
+	tests := []struct {
+		version int
+		want    string
+	}{
+		{120000, "12.00"},
+		{121101, "12.11r1"},
+		{104103, "10.41r3"},
+	}
+	for _, tt := range tests {
+		if got := (xplane.Beacon{Version: tt.version}).VersionString(); got != tt.want {
+			t.Errorf("VersionString(%d) = %q, want %q", tt.version, got, tt.want)
+		}
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	group, _ := net.ResolveUDPAddr("udp4", xplane.BeaconAddress)
+	send, err := net.DialUDP("udp4", nil, group)
+	if err != nil {
+		t.Skipf("no multicast: %v", err)
+	}
+	defer send.Close()
+
+	// Beacon until Discover has had a chance to join the group
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		packet := xplanetest.BeaconPacket("bushtalk-test", 121101, xplane.RoleExternalVisual, 49123)
+		for ctx.Err() == nil {
+			send.Write(packet)
+			time.Sleep(20 * time.Millisecond)
+		}
+	}()
+
+	beacons, err := xplane.Discover(ctx, 500*time.Millisecond)
+	if err != nil {
+		t.Skipf("can't join the beacon group: %v", err)
+	}
+	for _, b := range beacons {
+		if b.Hostname == "bushtalk-test" && b.UDPPort == 49123 && b.Role == xplane.RoleExternalVisual {
+			return
+		}
+	}
+	t.Errorf("Discover() = %v, want the test beacon", beacons)
+}
@@ -0,0 +1,104 @@
+package xplane
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// CaptureRecord is one line of a WebSocket capture, written as JSON Lines.
+// A capture starts with the dataref IDs X-Plane assigned, followed by every
+// message exactly as X-Plane sent it.
+type CaptureRecord struct {
+	Time     time.Time       `json:"time"`
+	Datarefs DatarefMap      `json:"datarefs,omitempty"` // IDs used by the messages that follow
+	Message  json.RawMessage `json:"message,omitempty"`
+}
+
+// capture writes records to a capture file; it's shared by reconnecting
+// clients, so writes are serialised
+type capture struct {
+	enc *json.Encoder
+	mu  sync.Mutex
+}
+
+func newCapture(w io.Writer) *capture {
+	if w == nil {
+		return nil
+	}
+	return &capture{enc: json.NewEncoder(w)}
+}
+
+// write appends a record. A full disk shouldn't stop tracking, so errors
+// are dropped.
+func (c *capture) write(r CaptureRecord) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.enc.Encode(r)
+}
+
+// indexByKey maps the JSON keys of X-Plane's value messages (IDs as strings)
+// to the datarefs they update
+func indexByKey(datarefs []*Dataref, ids DatarefMap) map[string][]*Dataref {
+	byKey := make(map[string][]*Dataref)
+	for _, d := range datarefs {
+		if id, ok := ids[d.Name]; ok {
+			key := strconv.FormatInt(id, 10)
+			byKey[key] = append(byKey[key], d)
+		}
+	}
+	return byKey
+}
+
+// ReadCapture plays back a capture written with SetCapture, calling fn with
+// the position after each message that carried dataref values. Datarefs are
+// decoded with registry, or DefaultRegistry if nil.
+func ReadCapture(r io.Reader, registry *Registry, fn func(at time.Time, p Position)) error {
+	if registry == nil {
+		registry = DefaultRegistry
+	}
+	var byKey map[string][]*Dataref
+	var position Position
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var rec CaptureRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		if rec.Datarefs != nil {
+			// X-Plane restarted or we reconnected; the IDs may have changed
+			byKey = indexByKey(registry.Datarefs(), rec.Datarefs)
+		}
+		if len(rec.Message) == 0 {
+			continue
+		}
+		if byKey == nil {
+			return fmt.Errorf("line %d: message before the dataref IDs", line)
+		}
+
+		var msg wsResponse
+		if err := json.Unmarshal(rec.Message, &msg); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		if len(msg.Data) == 0 {
+			continue
+		}
+		for key, raw := range msg.Data {
+			for _, d := range byKey[key] {
+				d.ApplyJSON(&position, raw)
+			}
+		}
+		position.Timestamp = rec.Time
+		fn(rec.Time, position)
+	}
+	return scanner.Err()
+}
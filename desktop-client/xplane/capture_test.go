@@ -0,0 +1,44 @@
+package xplane_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/bushtalkradio/xplane-client/xplane"
+)
+
+func TestCaptureRoundTrip(t *testing.T) {
+	s := newServer(t)
+	var buf bytes.Buffer
+	c := xplane.NewClient(xplane.DefaultHost, s.Port())
+	c.SetCapture(&buf)
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	if err := s.WaitSubscribed(waitTimeout); err != nil {
+		t.Fatal(err)
+	}
+	s.SetValue(xplane.DatarefLatitude, 60.5)
+	waitFor(t, "update", func() bool { return c.GetPosition().Latitude == 60.5 })
+	c.Disconnect()
+	<-c.Done()
+
+	var positions []xplane.Position
+	err := xplane.ReadCapture(&buf, nil, func(at time.Time, p xplane.Position) {
+		positions = append(positions, p)
+	})
+	if err != nil {
+		t.Fatalf("ReadCapture: %v", err)
+	}
+	if len(positions) < 2 {
+		t.Fatalf("got %d positions, want the initial values and the update", len(positions))
+	}
+	first, last := positions[0], positions[len(positions)-1]
+	if first.Latitude != 61.2176 || first.TailNumber != "N185BT" {
+		t.Errorf("first position = %+v", first)
+	}
+	if last.Latitude != 60.5 || last.Longitude != -149.8997 {
+		t.Errorf("last position = %+v, want the update on top of the initial values", last)
+	}
+}
@@ -1,37 +1,43 @@
 package xplane
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"net"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
+
+	"github.com/bushtalkradio/xplane-client/sim"
 )
 
-// Position holds the current flight position data
-type Position struct {
-	Latitude    float64
-	Longitude   float64
-	AltitudeAGL float64 // meters
-	Groundspeed float64 // m/s
-	Heading     float64 // magnetic heading
-	TailNumber  string
-	Timestamp   time.Time
-}
-
-// IsValid returns true if we have received position data
-func (p *Position) IsValid() bool {
-	return p.Latitude != 0 || p.Longitude != 0
-}
+// Position is the flight state read from X-Plane
+type Position = sim.Position
 
 // Client handles WebSocket communication with X-Plane
 type Client struct {
+	host         string
 	port         int
+	resolver     *Resolver
+	registry     *Registry
 	conn         *websocket.Conn
+	connMu       sync.Mutex // guards conn against a concurrent Disconnect
 	datarefMap   DatarefMap
-	reverseMap   map[int64]string
+	byKey        map[string][]*Dataref // JSON key (ID as string) to datarefs
+	debug        bool
+	capture      *capture // nil unless capturing
+	staleTimeout time.Duration
+	connectedAt  time.Time
+	readBuf      bytes.Buffer // reused by handleMessage
+	resp         wsResponse   // reused by handleMessage
 	position     Position
 	positionMu   sync.RWMutex
 	connected    bool
@@ -39,6 +45,7 @@ type Client struct {
 	onConnect    func()
 	onDisconnect func()
 	stopCh       chan struct{}
+	stopOnce     sync.Once
 	doneCh       chan struct{} // signals when connection is lost
 }
 
@@ -58,21 +65,74 @@ type datarefSub struct {
 }
 
 type wsResponse struct {
-	ReqID   int                    `json:"req_id"`
-	Type    string                 `json:"type"`
-	Success bool                   `json:"success"`
-	Data    map[string]interface{} `json:"data,omitempty"`
+	ReqID        int                        `json:"req_id"`
+	Type         string                     `json:"type"`
+	Success      bool                       `json:"success"`
+	ErrorCode    string                     `json:"error_code,omitempty"`
+	ErrorMessage string                     `json:"error_message,omitempty"`
+	Data         map[string]json.RawMessage `json:"data,omitempty"`
 }
 
-// NewClient creates a new X-Plane client
-func NewClient(port int) *Client {
+// subscribeReqID identifies the result message of our subscription request
+const subscribeReqID = 1
+
+const (
+	// pingInterval is how often we ping X-Plane to check the socket is alive
+	pingInterval = 10 * time.Second
+	// readTimeout is how long we wait for any message or pong before
+	// treating the connection as half-open
+	readTimeout = 30 * time.Second
+	// writeTimeout bounds control frame writes
+	writeTimeout = 5 * time.Second
+
+	// DefaultStaleTimeout is how long without dataref updates before the
+	// connection is treated as stalled
+	DefaultStaleTimeout = 30 * time.Second
+)
+
+// NewClient creates a client for the X-Plane Web API at host and port
+func NewClient(host string, port int) *Client {
 	return &Client{
-		port:   port,
-		stopCh: make(chan struct{}),
-		doneCh: make(chan struct{}),
+		host:         host,
+		port:         port,
+		resolver:     NewResolver(host, port),
+		registry:     DefaultRegistry,
+		staleTimeout: DefaultStaleTimeout,
+		stopCh:       make(chan struct{}),
+		doneCh:       make(chan struct{}),
 	}
 }
 
+// SetDebug enables logging of every WebSocket message
+func (c *Client) SetDebug(debug bool) {
+	c.debug = debug
+}
+
+// SetCapture records every message X-Plane sends to w as JSON Lines, for
+// playing back with ReadCapture. Call before Connect.
+func (c *Client) SetCapture(w io.Writer) {
+	c.capture = newCapture(w)
+}
+
+// SetStaleTimeout sets how long the connection may go without dataref
+// updates before it is closed as stalled. 0 disables the watchdog. Call
+// before Connect.
+func (c *Client) SetStaleTimeout(d time.Duration) {
+	c.staleTimeout = d
+}
+
+// SetRegistry sets the datarefs to subscribe to, replacing DefaultRegistry.
+// Call before Connect.
+func (c *Client) SetRegistry(registry *Registry) {
+	c.registry = registry
+}
+
+// SetResolver shares a dataref ID cache between clients, so reconnecting to
+// the same X-Plane session doesn't look the IDs up again
+func (c *Client) SetResolver(resolver *Resolver) {
+	c.resolver = resolver
+}
+
 // Done returns a channel that is closed when the connection is lost
 func (c *Client) Done() <-chan struct{} {
 	return c.doneCh
@@ -86,21 +146,49 @@ func (c *Client) SetCallbacks(onConnect, onDisconnect func()) {
 
 // Connect resolves dataref IDs and establishes WebSocket connection
 func (c *Client) Connect() error {
+	return c.ConnectContext(context.Background())
+}
+
+// ConnectContext is like Connect but aborts the dataref lookup and WebSocket
+// dial when ctx is cancelled. Once connected, use Disconnect to close.
+func (c *Client) ConnectContext(ctx context.Context) error {
 	// Step 1: Resolve dataref names to session IDs via REST API
-	datarefMap, err := ResolveDatarefIDs(c.port, AllDatarefs)
+	datarefs := c.registry.Datarefs()
+	datarefMap, missing, err := c.resolver.Resolve(ctx, c.registry.Names())
 	if err != nil {
 		return fmt.Errorf("failed to resolve datarefs: %w", err)
 	}
+	for _, name := range c.registry.RequiredNames() {
+		if _, ok := datarefMap[name]; !ok {
+			// Look again next time rather than trusting the cached answer
+			c.resolver.Invalidate()
+			return fmt.Errorf("failed to resolve datarefs: %s not found", name)
+		}
+	}
+	if len(missing) > 0 {
+		log.Printf("Datarefs not available, continuing without them: %s", strings.Join(missing, ", "))
+	}
 	c.datarefMap = datarefMap
-	c.reverseMap = datarefMap.ReverseMap()
+	c.byKey = indexByKey(datarefs, datarefMap)
+	c.capture.write(CaptureRecord{Time: time.Now(), Datarefs: datarefMap})
 
 	// Step 2: Connect to WebSocket
-	wsURL := fmt.Sprintf("ws://localhost:%d/api/v3", c.port)
-	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	wsURL := fmt.Sprintf("ws://%s/api/v3", net.JoinHostPort(c.host, strconv.Itoa(c.port)))
+	conn, err := dialWebSocket(ctx, wsURL)
 	if err != nil {
 		return fmt.Errorf("WebSocket connection failed: %w", err)
 	}
+	c.connMu.Lock()
+	select {
+	case <-c.stopCh:
+		// Disconnect was called while we were dialling
+		c.connMu.Unlock()
+		conn.Close()
+		return errors.New("disconnected while connecting")
+	default:
+	}
 	c.conn = conn
+	c.connMu.Unlock()
 
 	// Step 3: Subscribe to datarefs using numeric IDs
 	if err := c.subscribe(); err != nil {
@@ -108,6 +196,12 @@ func (c *Client) Connect() error {
 		return fmt.Errorf("failed to subscribe: %w", err)
 	}
 
+	c.connectedAt = time.Now()
+	c.conn.SetReadDeadline(time.Now().Add(readTimeout))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(readTimeout))
+	})
+
 	c.setConnected(true)
 	if c.onConnect != nil {
 		c.onConnect()
@@ -115,10 +209,39 @@ func (c *Client) Connect() error {
 
 	// Start reading messages
 	go c.readLoop()
+	go c.keepalive()
 
 	return nil
 }
 
+// dialWebSocket opens a WebSocket to url. gorilla/websocket only applies
+// ctx's deadline to the handshake, so cancelling ctx closes the connection
+// under it.
+func dialWebSocket(ctx context.Context, url string) (*websocket.Conn, error) {
+	stop := func() bool { return true }
+	dialer := *websocket.DefaultDialer
+	dialer.NetDialContext = func(dialCtx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := (&net.Dialer{}).DialContext(dialCtx, network, addr)
+		if err == nil {
+			stop = context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+		}
+		return conn, err
+	}
+
+	conn, _, err := dialer.DialContext(ctx, url, nil)
+	if !stop() {
+		if err == nil {
+			// Cancelled just as the handshake finished
+			conn.Close()
+		}
+		return nil, ctx.Err()
+	}
+	if err != nil && ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return conn, err
+}
+
 // subscribe sends subscription request for all datarefs
 func (c *Client) subscribe() error {
 	var subs []datarefSub
@@ -127,7 +250,7 @@ func (c *Client) subscribe() error {
 	}
 
 	msg := wsMessage{
-		ReqID: 1,
+		ReqID: subscribeReqID,
 		Type:  "dataref_subscribe_values",
 		Params: subscribeParams{
 			Datarefs: subs,
@@ -154,24 +277,78 @@ func (c *Client) readLoop() {
 		default:
 		}
 
-		_, message, err := c.conn.ReadMessage()
+		_, r, err := c.conn.NextReader()
+		if err == nil {
+			err = c.conn.SetReadDeadline(time.Now().Add(readTimeout))
+		}
 		if err != nil {
 			log.Printf("WebSocket read error: %v", err)
 			return
 		}
 
-		// Debug: log first few messages
-		log.Printf("WS message: %s", string(message[:min(len(message), 500)]))
+		if c.debug || c.capture != nil {
+			message, err := io.ReadAll(r)
+			if err != nil {
+				log.Printf("WebSocket read error: %v", err)
+				return
+			}
+			if c.debug {
+				log.Printf("WS message: %s", string(message[:min(len(message), 500)]))
+			}
+			c.capture.write(CaptureRecord{Time: time.Now(), Message: message})
+			r = bytes.NewReader(message)
+		}
 
-		var resp wsResponse
-		if err := json.Unmarshal(message, &resp); err != nil {
-			log.Printf("JSON unmarshal error: %v", err)
-			continue
+		if err := c.handleMessage(r); err != nil {
+			if errors.Is(err, errSubscriptionRejected) {
+				log.Printf("%v", err)
+				// Stale IDs from a previous X-Plane session; the next
+				// connection looks them up again
+				c.resolver.Invalidate()
+				return
+			}
+			if c.debug {
+				log.Printf("JSON decode error: %v", err)
+			}
 		}
+	}
+}
+
+// keepalive pings X-Plane so a half-open socket times out, and closes the
+// connection if dataref updates stop arriving even though the socket is up,
+// e.g. while X-Plane is frozen
+func (c *Client) keepalive() {
+	ping := time.NewTicker(pingInterval)
+	defer ping.Stop()
+
+	var watchdog <-chan time.Time
+	if c.staleTimeout > 0 {
+		t := time.NewTicker(c.staleTimeout / 4)
+		defer t.Stop()
+		watchdog = t.C
+	}
 
-		// Process dataref values
-		if resp.Data != nil {
-			c.updatePosition(resp.Data)
+	for {
+		select {
+		case <-c.stopCh:
+			return
+		case <-c.doneCh:
+			return
+		case <-ping.C:
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeTimeout)); err != nil {
+				// The read loop will see the failure too
+				return
+			}
+		case <-watchdog:
+			last := c.GetPosition().Timestamp
+			if last.IsZero() {
+				last = c.connectedAt
+			}
+			if age := time.Since(last); age > c.staleTimeout {
+				log.Printf("No updates from X-Plane for %v, treating the connection as stalled", age.Round(time.Second))
+				c.conn.Close()
+				return
+			}
 		}
 	}
 }
@@ -183,45 +360,46 @@ func min(a, b int) int {
 	return b
 }
 
+// errSubscriptionRejected is returned when X-Plane refuses our dataref subscription
+var errSubscriptionRejected = errors.New("subscription rejected")
+
+// handleMessage reads one WebSocket message into a reused buffer and
+// applies any dataref values it carries
+func (c *Client) handleMessage(r io.Reader) error {
+	c.readBuf.Reset()
+	if _, err := c.readBuf.ReadFrom(r); err != nil {
+		return err
+	}
+
+	// Keep the map between messages to avoid reallocating it
+	resp := &c.resp
+	data := resp.Data
+	clear(data)
+	*resp = wsResponse{Data: data}
+	if err := json.Unmarshal(c.readBuf.Bytes(), resp); err != nil {
+		return err
+	}
+
+	if resp.Type == "result" && resp.ReqID == subscribeReqID && !resp.Success {
+		return fmt.Errorf("%w: %s %s", errSubscriptionRejected, resp.ErrorCode, resp.ErrorMessage)
+	}
+
+	// Process dataref values
+	if len(resp.Data) > 0 {
+		c.updatePosition(resp.Data)
+	}
+	return nil
+}
+
 // updatePosition updates the current position from WebSocket data
-func (c *Client) updatePosition(data map[string]interface{}) {
+func (c *Client) updatePosition(data map[string]json.RawMessage) {
 	c.positionMu.Lock()
 	defer c.positionMu.Unlock()
 
-	for idStr, value := range data {
-		// Parse the string ID back to int64
-		var id int64
-		fmt.Sscanf(idStr, "%d", &id)
-
-		name, ok := c.reverseMap[id]
-		if !ok {
-			continue
-		}
-
-		// Values come directly (not wrapped in {"value": ...})
-		switch name {
-		case DatarefLatitude:
-			if v, ok := value.(float64); ok {
-				c.position.Latitude = v
-			}
-		case DatarefLongitude:
-			if v, ok := value.(float64); ok {
-				c.position.Longitude = v
-			}
-		case DatarefAltitudeAGL:
-			if v, ok := value.(float64); ok {
-				c.position.AltitudeAGL = v
-			}
-		case DatarefGroundspeed:
-			if v, ok := value.(float64); ok {
-				c.position.Groundspeed = v
-			}
-		case DatarefHeading:
-			if v, ok := value.(float64); ok {
-				c.position.Heading = v
-			}
-		case DatarefTailNum:
-			c.position.TailNumber = DecodeTailNumber(value)
+	// Values come directly (not wrapped in {"value": ...}), keyed by ID string
+	for key, raw := range data {
+		for _, d := range c.byKey[key] {
+			d.ApplyJSON(&c.position, raw)
 		}
 	}
 	c.position.Timestamp = time.Now()
@@ -234,6 +412,24 @@ func (c *Client) GetPosition() Position {
 	return c.position
 }
 
+// Snapshot returns the current position; see sim.FlightSource
+func (c *Client) Snapshot() Position {
+	return c.GetPosition()
+}
+
+// Capabilities reports everything; the Web API has every dataref we use
+func (c *Client) Capabilities() sim.Capabilities {
+	return sim.Capabilities{
+		Name:          "X-Plane Web API",
+		OnGround:      true,
+		SimState:      true,
+		AircraftType:  true,
+		AircraftTitle: true,
+		Livery:        true,
+		FlightState:   true,
+	}
+}
+
 // IsConnected returns true if connected to X-Plane
 func (c *Client) IsConnected() bool {
 	c.connectedMu.RLock()
@@ -247,11 +443,16 @@ func (c *Client) setConnected(connected bool) {
 	c.connectedMu.Unlock()
 }
 
-// Disconnect closes the WebSocket connection
+// Disconnect closes the WebSocket connection. It is safe to call more than
+// once and from any goroutine.
 func (c *Client) Disconnect() {
-	close(c.stopCh)
-	if c.conn != nil {
-		c.conn.Close()
-	}
+	c.stopOnce.Do(func() {
+		c.connMu.Lock()
+		close(c.stopCh)
+		if c.conn != nil {
+			c.conn.Close()
+		}
+		c.connMu.Unlock()
+	})
 	c.setConnected(false)
 }
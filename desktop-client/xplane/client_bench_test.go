@@ -0,0 +1,85 @@
+package xplane
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"testing"
+)
+
+// benchMessage is a typical dataref update as pushed by X-Plane
+var benchMessage = []byte(`{"type":"dataref_update_values","data":{` +
+	`"1001":47.4647,"1002":-121.4865,"1003":152.3,"1004":48.7,"1005":274.2,` +
+	`"1006":"TjE4NUJUAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=="}}`)
+
+// benchClient returns a client wired up as if Connect had resolved the default registry
+func benchClient() *Client {
+	c := NewClient(DefaultHost, 8086)
+	c.datarefMap = make(DatarefMap)
+	c.byKey = make(map[string][]*Dataref)
+	for i, d := range DefaultRegistry.Datarefs() {
+		id := int64(1001 + i)
+		c.datarefMap[d.Name] = id
+		key := strconv.FormatInt(id, 10)
+		c.byKey[key] = append(c.byKey[key], d)
+	}
+	return c
+}
+
+func BenchmarkHandleMessage(b *testing.B) {
+	c := benchClient()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := c.handleMessage(bytes.NewReader(benchMessage)); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if pos := c.GetPosition(); pos.TailNumber != "N185BT" {
+		b.Fatalf("tail number = %q", pos.TailNumber)
+	}
+}
+
+// BenchmarkHandleMessageLegacy measures the previous read path for comparison:
+// log the message, unmarshal into interface{} values, then Sscanf and a name
+// switch per key.
+func BenchmarkHandleMessageLegacy(b *testing.B) {
+	c := benchClient()
+	reverseMap := c.datarefMap.ReverseMap()
+	logger := log.New(io.Discard, "", log.LstdFlags)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		logger.Printf("WS message: %s", string(benchMessage[:min(len(benchMessage), 500)]))
+
+		var resp struct {
+			Data map[string]interface{} `json:"data"`
+		}
+		if err := json.Unmarshal(benchMessage, &resp); err != nil {
+			b.Fatal(err)
+		}
+		c.positionMu.Lock()
+		for idStr, value := range resp.Data {
+			var id int64
+			fmt.Sscanf(idStr, "%d", &id)
+			switch reverseMap[id] {
+			case DatarefLatitude:
+				c.position.Latitude, _ = value.(float64)
+			case DatarefLongitude:
+				c.position.Longitude, _ = value.(float64)
+			case DatarefAltitudeAGL:
+				c.position.AltitudeAGL, _ = value.(float64)
+			case DatarefGroundspeed:
+				c.position.Groundspeed, _ = value.(float64)
+			case DatarefHeading:
+				c.position.Heading, _ = value.(float64)
+			case DatarefTailNum:
+				c.position.TailNumber = DecodeTailNumber(value)
+			}
+		}
+		c.positionMu.Unlock()
+	}
+}
@@ -0,0 +1,472 @@
+package xplane_test
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bushtalkradio/xplane-client/xplane"
+	"github.com/bushtalkradio/xplane-client/xplane/xplanetest"
+)
+
+const waitTimeout = 2 * time.Second
+
+// newServer starts a fake X-Plane serving all default datarefs
+func newServer(t *testing.T) *xplanetest.Server {
+	t.Helper()
+	s := xplanetest.NewServer()
+	t.Cleanup(s.Close)
+
+	s.AddDataref(xplane.DatarefLatitude, "double", 61.2176)
+	s.AddDataref(xplane.DatarefLongitude, "double", -149.8997)
+	s.AddDataref(xplane.DatarefAltitudeAGL, "float", 304.8)
+	s.AddDataref(xplane.DatarefGroundspeed, "float", 51.4)
+	s.AddDataref(xplane.DatarefHeading, "float", 92.5)
+	s.AddDataref(xplane.DatarefTailNum, "data", base64.StdEncoding.EncodeToString([]byte("N185BT\x00\x00\x00")))
+	return s
+}
+
+// connect connects a client to s and disconnects it at the end of the test
+func connect(t *testing.T, s *xplanetest.Server, resolver *xplane.Resolver) *xplane.Client {
+	t.Helper()
+	c := xplane.NewClient(xplane.DefaultHost, s.Port())
+	if resolver != nil {
+		c.SetResolver(resolver)
+	}
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	t.Cleanup(func() {
+		select {
+		case <-c.Done():
+		default:
+			c.Disconnect()
+		}
+	})
+	return c
+}
+
+// waitFor polls until cond is true
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(waitTimeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestConnectReceivesPosition(t *testing.T) {
+	s := newServer(t)
+	c := connect(t, s, nil)
+
+	if !c.IsConnected() {
+		t.Fatal("IsConnected() = false after Connect")
+	}
+
+	waitFor(t, "initial values", func() bool { return c.GetPosition().TailNumber != "" })
+	pos := c.GetPosition()
+	if pos.Latitude != 61.2176 || pos.Longitude != -149.8997 {
+		t.Errorf("position = %v, %v", pos.Latitude, pos.Longitude)
+	}
+	if pos.AltitudeAGL != 304.8 || pos.Groundspeed != 51.4 || pos.Heading != 92.5 {
+		t.Errorf("alt/speed/heading = %v/%v/%v", pos.AltitudeAGL, pos.Groundspeed, pos.Heading)
+	}
+	if pos.TailNumber != "N185BT" {
+		t.Errorf("TailNumber = %q, want N185BT", pos.TailNumber)
+	}
+	if !pos.IsValid() {
+		t.Error("IsValid() = false")
+	}
+}
+
+func TestReadLoopAppliesUpdates(t *testing.T) {
+	s := newServer(t)
+	c := connect(t, s, nil)
+	if err := s.WaitSubscribed(waitTimeout); err != nil {
+		t.Fatal(err)
+	}
+
+	s.SetValue(xplane.DatarefLatitude, 60.5)
+	s.SetValue(xplane.DatarefHeading, 180.0)
+
+	waitFor(t, "updated values", func() bool {
+		pos := c.GetPosition()
+		return pos.Latitude == 60.5 && pos.Heading == 180
+	})
+	if got := c.GetPosition().Longitude; got != -149.8997 {
+		t.Errorf("Longitude changed to %v by unrelated update", got)
+	}
+}
+
+func TestConnectWithoutOptionalDataref(t *testing.T) {
+	s := xplanetest.NewServer()
+	t.Cleanup(s.Close)
+	s.AddDataref(xplane.DatarefLatitude, "double", 61.2)
+	s.AddDataref(xplane.DatarefLongitude, "double", -149.9)
+
+	c := connect(t, s, nil)
+	waitFor(t, "position", func() bool { return c.GetPosition().IsValid() })
+}
+
+func TestConnectWithoutRequiredDataref(t *testing.T) {
+	s := xplanetest.NewServer()
+	t.Cleanup(s.Close)
+	s.AddDataref(xplane.DatarefLatitude, "double", 61.2)
+
+	c := xplane.NewClient(xplane.DefaultHost, s.Port())
+	if err := c.Connect(); err == nil {
+		c.Disconnect()
+		t.Fatal("Connect succeeded without longitude dataref")
+	}
+}
+
+func TestConnectLookupFailure(t *testing.T) {
+	s := newServer(t)
+	s.FailLookups(http.StatusInternalServerError)
+
+	c := xplane.NewClient(xplane.DefaultHost, s.Port())
+	if err := c.Connect(); err == nil {
+		c.Disconnect()
+		t.Fatal("Connect succeeded although the lookup failed")
+	}
+	if c.IsConnected() {
+		t.Error("IsConnected() = true after failed Connect")
+	}
+}
+
+// silentPort accepts TCP connections and never answers, like a hung X-Plane
+func silentPort(t *testing.T) int {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	var conns []net.Conn
+	t.Cleanup(func() {
+		l.Close()
+		mu.Lock()
+		for _, c := range conns {
+			c.Close()
+		}
+		mu.Unlock()
+	})
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			conns = append(conns, c)
+			mu.Unlock()
+		}
+	}()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+// connectCancelled connects c, cancelling part way through, and checks
+// that it gives up promptly
+func connectCancelled(t *testing.T, c *xplane.Client) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	err := c.ConnectContext(ctx)
+	if err == nil {
+		c.Disconnect()
+		t.Fatal("Connect succeeded against a silent X-Plane")
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Connect took %v to notice the cancellation", elapsed)
+	}
+}
+
+func TestConnectCancelledDuringLookup(t *testing.T) {
+	connectCancelled(t, xplane.NewClient(xplane.DefaultHost, silentPort(t)))
+}
+
+func TestConnectCancelledDuringDial(t *testing.T) {
+	// Resolve against a working X-Plane, so only the WebSocket dial hangs
+	s := newServer(t)
+	resolver := xplane.NewResolver(xplane.DefaultHost, s.Port())
+	connect(t, s, resolver).Disconnect()
+
+	c := xplane.NewClient(xplane.DefaultHost, silentPort(t))
+	c.SetResolver(resolver)
+	connectCancelled(t, c)
+}
+
+func TestDisconnectDetected(t *testing.T) {
+	s := newServer(t)
+
+	disconnected := make(chan struct{}, 1)
+	c := xplane.NewClient(xplane.DefaultHost, s.Port())
+	c.SetCallbacks(nil, func() { disconnected <- struct{}{} })
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	if err := s.WaitSubscribed(waitTimeout); err != nil {
+		t.Fatal(err)
+	}
+
+	s.DropConnections()
+
+	select {
+	case <-c.Done():
+	case <-time.After(waitTimeout):
+		t.Fatal("Done() not closed after the connection dropped")
+	}
+	select {
+	case <-disconnected:
+	case <-time.After(waitTimeout):
+		t.Fatal("onDisconnect not called")
+	}
+	if c.IsConnected() {
+		t.Error("IsConnected() = true after the connection dropped")
+	}
+}
+
+func TestReconnectReusesResolvedIDs(t *testing.T) {
+	s := newServer(t)
+	resolver := xplane.NewResolver(xplane.DefaultHost, s.Port())
+
+	first := connect(t, s, resolver)
+	if err := s.WaitSubscribed(waitTimeout); err != nil {
+		t.Fatal(err)
+	}
+	// The socket drops but X-Plane keeps running
+	s.DropConnections()
+	<-first.Done()
+
+	second := connect(t, s, resolver)
+	waitFor(t, "position after reconnect", func() bool { return second.GetPosition().IsValid() })
+
+	if n := s.Lookups(); n != 1 {
+		t.Errorf("Lookups() = %d after reconnecting to the same session, want 1", n)
+	}
+}
+
+func TestReconnectAfterXPlaneRestart(t *testing.T) {
+	s := newServer(t)
+	resolver := xplane.NewResolver(xplane.DefaultHost, s.Port())
+
+	first := connect(t, s, resolver)
+	if err := s.WaitSubscribed(waitTimeout); err != nil {
+		t.Fatal(err)
+	}
+
+	// X-Plane restarts: the socket drops and every ID changes
+	s.Renumber()
+	s.DropConnections()
+	<-first.Done()
+
+	// The cached IDs are tried first and rejected
+	stale := connect(t, s, resolver)
+	select {
+	case <-stale.Done():
+	case <-time.After(waitTimeout):
+		t.Fatal("connection with stale IDs wasn't closed")
+	}
+
+	second := connect(t, s, resolver)
+	waitFor(t, "position after restart", func() bool { return second.GetPosition().IsValid() })
+	if n := s.Lookups(); n != 2 {
+		t.Errorf("Lookups() = %d, want IDs resolved again after the restart", n)
+	}
+}
+
+func TestStaleIDsRejected(t *testing.T) {
+	s := newServer(t)
+	resolver := xplane.NewResolver(xplane.DefaultHost, s.Port())
+
+	first := connect(t, s, resolver)
+	first.Disconnect()
+	<-first.Done()
+
+	// IDs changed without us noticing the socket drop
+	s.Renumber()
+	stale := connect(t, s, resolver)
+	select {
+	case <-stale.Done():
+	case <-time.After(waitTimeout):
+		t.Fatal("connection with stale IDs wasn't closed")
+	}
+
+	fresh := connect(t, s, resolver)
+	waitFor(t, "position with fresh IDs", func() bool { return fresh.GetPosition().IsValid() })
+}
+
+func TestDisconnectTwice(t *testing.T) {
+	s := newServer(t)
+	c := connect(t, s, nil)
+
+	c.Disconnect()
+	c.Disconnect()
+
+	select {
+	case <-c.Done():
+	case <-time.After(waitTimeout):
+		t.Fatal("Done not closed after Disconnect")
+	}
+}
+
+func TestStalledConnectionClosed(t *testing.T) {
+	s := newServer(t)
+	c := xplane.NewClient(xplane.DefaultHost, s.Port())
+	c.SetStaleTimeout(100 * time.Millisecond)
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	defer c.Disconnect()
+
+	// The fake sends initial values, then nothing, like a frozen X-Plane
+	select {
+	case <-c.Done():
+	case <-time.After(waitTimeout):
+		t.Fatal("connection not closed after updates stopped")
+	}
+}
+
+func TestUpdatesKeepConnectionAlive(t *testing.T) {
+	s := newServer(t)
+	c := xplane.NewClient(xplane.DefaultHost, s.Port())
+	c.SetStaleTimeout(200 * time.Millisecond)
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	defer c.Disconnect()
+
+	for i := 0; i < 10; i++ {
+		time.Sleep(50 * time.Millisecond)
+		s.Push()
+	}
+	select {
+	case <-c.Done():
+		t.Fatal("connection closed while updates were arriving")
+	default:
+	}
+}
+
+func TestSimStateDatarefs(t *testing.T) {
+	s := newServer(t)
+	s.AddDataref(xplane.DatarefPaused, "int", 1)
+	s.AddDataref(xplane.DatarefReplayMode, "int", 0)
+	s.AddDataref(xplane.DatarefSimSpeed, "int", 1)
+	s.AddDataref(xplane.DatarefGroundSpeed, "int", 4)
+	c := connect(t, s, nil)
+
+	waitFor(t, "paused", func() bool { return c.GetPosition().Paused })
+	pos := c.GetPosition()
+	if pos.Replay {
+		t.Error("Replay = true, want false")
+	}
+	if got := pos.TimeAcceleration(); got != 4 {
+		t.Errorf("TimeAcceleration() = %d, want 4", got)
+	}
+
+	s.SetValue(xplane.DatarefReplayMode, 1)
+	waitFor(t, "replay", func() bool { return c.GetPosition().Replay })
+}
+
+func TestTimeAcceleration(t *testing.T) {
+	tests := []struct {
+		simSpeed, compression, want int
+	}{
+		{0, 0, 1}, // datarefs unavailable
+		{1, 1, 1},
+		{2, 1, 2},
+		{1, 8, 8},
+		{2, 4, 8},
+	}
+	for _, tt := range tests {
+		p := xplane.Position{SimSpeed: tt.simSpeed, TimeCompression: tt.compression}
+		if got := p.TimeAcceleration(); got != tt.want {
+			t.Errorf("TimeAcceleration() with sim_speed=%d ground_speed=%d = %d, want %d",
+				tt.simSpeed, tt.compression, got, tt.want)
+		}
+	}
+}
+
+func TestOnGround(t *testing.T) {
+	tests := []struct {
+		name string
+		pos  xplane.Position
+		want bool
+	}{
+		{"from X-Plane, on water above 1m AGL", xplane.Position{AltitudeAGL: 1.5, OnGround: true, HasOnGround: true}, true},
+		{"from X-Plane, low pass", xplane.Position{AltitudeAGL: 0.5, HasOnGround: true}, false},
+		{"fallback on ground", xplane.Position{AltitudeAGL: 0.2}, true},
+		{"fallback airborne", xplane.Position{AltitudeAGL: 300}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.pos.IsOnGround(); got != tt.want {
+			t.Errorf("%s: IsOnGround() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestOnGroundDataref(t *testing.T) {
+	s := newServer(t)
+	s.AddDataref(xplane.DatarefOnGround, "int", 1)
+	c := connect(t, s, nil)
+
+	waitFor(t, "on-ground flag", func() bool { return c.GetPosition().HasOnGround })
+	if !c.GetPosition().IsOnGround() {
+		t.Error("IsOnGround() = false with onground_any = 1 at 304.8m AGL")
+	}
+}
+
+func TestExtendedFlightState(t *testing.T) {
+	s := newServer(t)
+	s.AddDataref(xplane.DatarefAltitudeMSL, "double", 1524.0)
+	s.AddDataref(xplane.DatarefIAS, "float", 95.0)
+	s.AddDataref(xplane.DatarefVS, "float", -350.0)
+	s.AddDataref(xplane.DatarefTrueHeading, "float", 110.5)
+	s.AddDataref(xplane.DatarefTrack, "float", 108.0)
+	s.AddDataref(xplane.DatarefPitch, "float", 2.5)
+	s.AddDataref(xplane.DatarefRoll, "float", -15.0)
+	c := connect(t, s, nil)
+
+	waitFor(t, "extended state", func() bool { return c.GetPosition().AltitudeMSL != 0 })
+	pos := c.GetPosition()
+	if pos.IndicatedAirspeed != 95 || pos.VerticalSpeed != -350 {
+		t.Errorf("IAS/VS = %v/%v, want 95/-350", pos.IndicatedAirspeed, pos.VerticalSpeed)
+	}
+	if pos.TrueHeading != 110.5 || pos.Track != 108 {
+		t.Errorf("true heading/track = %v/%v, want 110.5/108", pos.TrueHeading, pos.Track)
+	}
+	if pos.Pitch != 2.5 || pos.Roll != -15 {
+		t.Errorf("pitch/roll = %v/%v, want 2.5/-15", pos.Pitch, pos.Roll)
+	}
+}
+
+func TestAircraftIdentity(t *testing.T) {
+	s := newServer(t)
+	s.AddDataref(xplane.DatarefICAO, "data", base64.StdEncoding.EncodeToString([]byte("C185\x00")))
+	s.AddDataref(xplane.DatarefDescription, "data", base64.StdEncoding.EncodeToString([]byte("Cessna 185 on floats\x00junk")))
+	s.AddDataref(xplane.DatarefAuthor, "data", base64.StdEncoding.EncodeToString([]byte("Bush Works\x00")))
+	s.AddDataref(xplane.DatarefLiveryPath, "data", base64.StdEncoding.EncodeToString([]byte("Aircraft/C185/liveries/Bush Red/\x00")))
+	c := connect(t, s, nil)
+
+	waitFor(t, "aircraft identity", func() bool { return c.GetPosition().AircraftICAO != "" })
+	pos := c.GetPosition()
+	if pos.AircraftICAO != "C185" || pos.AircraftDescription != "Cessna 185 on floats" {
+		t.Errorf("ICAO/description = %q/%q", pos.AircraftICAO, pos.AircraftDescription)
+	}
+	if pos.AircraftAuthor != "Bush Works" || pos.Livery != "Bush Red" {
+		t.Errorf("author/livery = %q/%q", pos.AircraftAuthor, pos.Livery)
+	}
+}
@@ -1,14 +1,18 @@
 package xplane
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -20,18 +24,25 @@ const (
 	DatarefGroundspeed = "sim/flightmodel/position/groundspeed"
 	DatarefHeading     = "sim/flightmodel/position/mag_psi"
 	DatarefTailNum     = "sim/aircraft/view/acf_tailnum"
+	DatarefICAO        = "sim/aircraft/view/acf_ICAO"
+	DatarefDescription = "sim/aircraft/view/acf_descrip"
+	DatarefAuthor      = "sim/aircraft/view/acf_author"
+	DatarefLiveryPath  = "sim/aircraft/view/acf_livery_path"
+	DatarefAltitudeMSL = "sim/flightmodel/position/elevation"
+	DatarefIAS         = "sim/flightmodel/position/indicated_airspeed"
+	DatarefVS          = "sim/flightmodel/position/vh_ind_fpm"
+	DatarefTrueHeading = "sim/flightmodel/position/psi"
+	DatarefTrack       = "sim/flightmodel/position/hpath"
+	DatarefPitch       = "sim/flightmodel/position/theta"
+	DatarefRoll        = "sim/flightmodel/position/phi"
+	DatarefOnGround    = "sim/flightmodel/failures/onground_any"
+	DatarefRunningTime = "sim/time/total_running_time_sec"
+	DatarefPaused      = "sim/time/paused"
+	DatarefReplayMode  = "sim/operation/prefs/replay_mode"
+	DatarefSimSpeed    = "sim/time/sim_speed"
+	DatarefGroundSpeed = "sim/time/ground_speed" // time compression, not aircraft speed
 )
 
-// AllDatarefs is the list of all datarefs we need
-var AllDatarefs = []string{
-	DatarefLatitude,
-	DatarefLongitude,
-	DatarefAltitudeAGL,
-	DatarefGroundspeed,
-	DatarefHeading,
-	DatarefTailNum,
-}
-
 // DatarefInfo holds metadata about a dataref
 type DatarefInfo struct {
 	ID        int64  `json:"id"`
@@ -56,37 +67,38 @@ func (m DatarefMap) ReverseMap() map[int64]string {
 	return reverse
 }
 
-// ResolveDatarefIDs queries the X-Plane REST API to get session-specific IDs for datarefs
-func ResolveDatarefIDs(port int, datarefs []string) (DatarefMap, error) {
-	result := make(DatarefMap)
-	client := &http.Client{Timeout: 5 * time.Second}
+// ResolveDatarefIDs queries the X-Plane REST API to get session-specific IDs
+// for datarefs. Names X-Plane doesn't know about (e.g. from an add-on aircraft
+// that isn't loaded) are returned as missing rather than failing the lookup.
+func ResolveDatarefIDs(host string, port int, datarefs []string) (DatarefMap, []string, error) {
+	return ResolveDatarefIDsContext(context.Background(), host, port, datarefs)
+}
 
-	for _, name := range datarefs {
-		id, err := resolveDataref(client, port, name)
-		if err != nil {
-			return nil, fmt.Errorf("failed to resolve %s: %w", name, err)
-		}
-		result[name] = id
+// ResolveDatarefIDsContext is like ResolveDatarefIDs but aborts when ctx is cancelled
+func ResolveDatarefIDsContext(ctx context.Context, host string, port int, datarefs []string) (DatarefMap, []string, error) {
+	if len(datarefs) == 0 {
+		return DatarefMap{}, nil, nil
 	}
 
-	return result, nil
-}
-
-// resolveDataref queries X-Plane for a single dataref's ID
-func resolveDataref(client *http.Client, port int, name string) (int64, error) {
+	// The Web API accepts repeated filters, so one request resolves everything.
 	// Use raw brackets - X-Plane may not handle URL-encoded brackets
-	apiURL := fmt.Sprintf("http://localhost:%d/api/v3/datarefs?filter[name]=%s", port, url.PathEscape(name))
+	filters := make([]string, len(datarefs))
+	for i, name := range datarefs {
+		filters[i] = "filter[name]=" + url.PathEscape(name)
+	}
+	apiURL := fmt.Sprintf("http://%s/api/v3/datarefs?%s", net.JoinHostPort(host, strconv.Itoa(port)), strings.Join(filters, "&"))
 
 	log.Printf("Requesting: %s", apiURL)
 
-	req, err := http.NewRequest("GET", apiURL, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
 	if err != nil {
-		return 0, err
+		return nil, nil, err
 	}
 
+	client := &http.Client{Timeout: 5 * time.Second}
 	resp, err := client.Do(req)
 	if err != nil {
-		return 0, err
+		return nil, nil, err
 	}
 	defer resp.Body.Close()
 
@@ -97,33 +109,125 @@ func resolveDataref(client *http.Client, port int, name string) (int64, error) {
 			preview = preview[:200]
 		}
 		log.Printf("Response body: %s", preview)
-		return 0, fmt.Errorf("API returned status %d", resp.StatusCode)
+		return nil, nil, fmt.Errorf("API returned status %d", resp.StatusCode)
 	}
 
 	var datarefResp DatarefResponse
 	if err := json.NewDecoder(resp.Body).Decode(&datarefResp); err != nil {
-		return 0, err
+		return nil, nil, err
+	}
+
+	result := make(DatarefMap)
+	for _, info := range datarefResp.Data {
+		result[info.Name] = info.ID
+	}
+
+	var missing []string
+	for _, name := range datarefs {
+		if _, ok := result[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+
+	return result, missing, nil
+}
+
+// Resolver resolves dataref names to IDs and caches the results, including
+// names that weren't found, for the lifetime of the X-Plane session. IDs are
+// only valid until X-Plane restarts. A restarted X-Plane rejects a
+// subscription to the old IDs, so the cache is invalidated then rather than
+// whenever the connection drops.
+type Resolver struct {
+	host    string
+	port    int
+	ids     DatarefMap
+	missing map[string]bool
+	mu      sync.Mutex
+}
+
+// NewResolver creates a resolver for the X-Plane instance at host and port
+func NewResolver(host string, port int) *Resolver {
+	return &Resolver{
+		host:    host,
+		port:    port,
+		ids:     make(DatarefMap),
+		missing: make(map[string]bool),
 	}
+}
+
+// Resolve returns the IDs of the given datarefs and the names that don't
+// exist, only querying X-Plane for names that aren't cached yet
+func (r *Resolver) Resolve(ctx context.Context, datarefs []string) (DatarefMap, []string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 
-	if len(datarefResp.Data) == 0 {
-		return 0, fmt.Errorf("dataref not found")
+	var uncached []string
+	for _, name := range datarefs {
+		if _, ok := r.ids[name]; !ok && !r.missing[name] {
+			uncached = append(uncached, name)
+		}
 	}
 
-	return datarefResp.Data[0].ID, nil
+	if len(uncached) > 0 {
+		ids, missing, err := ResolveDatarefIDsContext(ctx, r.host, r.port, uncached)
+		if err != nil {
+			return nil, nil, err
+		}
+		for name, id := range ids {
+			r.ids[name] = id
+		}
+		for _, name := range missing {
+			r.missing[name] = true
+		}
+	}
+
+	result := make(DatarefMap)
+	var missing []string
+	for _, name := range datarefs {
+		if id, ok := r.ids[name]; ok {
+			result[name] = id
+		} else {
+			missing = append(missing, name)
+		}
+	}
+	return result, missing, nil
+}
+
+// Invalidate clears the cache, e.g. because X-Plane may have restarted
+func (r *Resolver) Invalidate() {
+	r.mu.Lock()
+	r.ids = make(DatarefMap)
+	r.missing = make(map[string]bool)
+	r.mu.Unlock()
 }
 
 // DecodeTailNumber decodes the tail number from X-Plane's format
-// X-Plane returns byte arrays as base64 strings or int arrays
 func DecodeTailNumber(value interface{}) string {
+	return orUnknown(DecodeByteString(value))
+}
+
+// LiveryName returns the livery's folder name from acf_livery_path, or ""
+// for the aircraft's default paint
+func LiveryName(path string) string {
+	path = strings.TrimRight(strings.ReplaceAll(path, "\\", "/"), "/")
+	if path == "" {
+		return ""
+	}
+	return path[strings.LastIndexByte(path, '/')+1:]
+}
+
+// DecodeByteString decodes a string stored in a byte array dataref.
+// X-Plane returns byte arrays as base64 strings or int arrays.
+func DecodeByteString(value interface{}) string {
 	switch v := value.(type) {
 	case string:
 		// Try base64 decode
 		decoded, err := base64.StdEncoding.DecodeString(v)
 		if err == nil {
-			return cleanTailNumber(string(decoded))
+			return cleanByteString(string(decoded))
 		}
 		// Already a plain string
-		return cleanTailNumber(v)
+		return cleanByteString(v)
 
 	case []interface{}:
 		// Int array of byte values
@@ -136,19 +240,25 @@ func DecodeTailNumber(value interface{}) string {
 				bytes = append(bytes, byte(num))
 			}
 		}
-		return cleanTailNumber(string(bytes))
+		return cleanByteString(string(bytes))
 
 	default:
-		return "UNKNOWN"
+		return ""
 	}
 }
 
-// cleanTailNumber removes null bytes and trims whitespace
-func cleanTailNumber(s string) string {
-	// Remove null bytes
-	s = strings.ReplaceAll(s, "\x00", "")
+// cleanByteString cuts the string at the null terminator and trims whitespace
+func cleanByteString(s string) string {
+	// Anything after the terminator is leftover buffer content
+	if i := strings.IndexByte(s, 0); i >= 0 {
+		s = s[:i]
+	}
 	// Trim whitespace
-	s = strings.TrimSpace(s)
+	return strings.TrimSpace(s)
+}
+
+// orUnknown substitutes "UNKNOWN" for an empty string
+func orUnknown(s string) string {
 	if s == "" {
 		return "UNKNOWN"
 	}
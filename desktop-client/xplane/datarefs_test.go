@@ -0,0 +1,47 @@
+package xplane
+
+import "testing"
+
+func TestLiveryName(t *testing.T) {
+	tests := []struct {
+		path, want string
+	}{
+		{"Aircraft/Laminar Research/Cessna 172/liveries/Bush Red/", "Bush Red"},
+		{"Aircraft\\Bush\\C185\\liveries\\Floats Yellow", "Floats Yellow"},
+		{"liveries/N185BT", "N185BT"},
+		{"", ""},
+		{"/", ""},
+	}
+
+	for _, tt := range tests {
+		if got := LiveryName(tt.path); got != tt.want {
+			t.Errorf("LiveryName(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeTailNumber(t *testing.T) {
+	tests := []struct {
+		name  string
+		value interface{}
+		want  string
+	}{
+		{"base64", "TjE4NUJUAAAA", "N185BT"},
+		{"base64 with junk after terminator", "TjE4NUJUAFhZWg==", "N185BT"},
+		{"plain string", "C-GBUSH", "C-GBUSH"},
+		{"byte array", []interface{}{78.0, 49.0, 50.0, 0.0, 65.0}, "N12"},
+		{"padded", "  N185BT  ", "N185BT"},
+		{"empty", "", "UNKNOWN"},
+		{"all nulls", []interface{}{0.0, 0.0}, "UNKNOWN"},
+		{"wrong type", 42.0, "UNKNOWN"},
+		{"nil", nil, "UNKNOWN"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DecodeTailNumber(tt.value); got != tt.want {
+				t.Errorf("DecodeTailNumber(%#v) = %q, want %q", tt.value, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package xplane
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"time"
+
+	"github.com/bushtalkradio/xplane-client/sim"
+)
+
+// Manager keeps a connection to X-Plane open, reconnecting whenever it
+// drops. It is a sim.Manager that dials X-Plane the configured way.
+type Manager struct {
+	*sim.Manager
+
+	host         string
+	port         int
+	udpPort      int
+	source       string
+	resolver     *Resolver
+	debug        bool
+	capture      *capture // shared by every connection
+	staleTimeout time.Duration
+}
+
+// NewManager creates a manager for the X-Plane at host, whose Web API is on
+// port. Call Run to start it.
+func NewManager(host string, port int) *Manager {
+	m := &Manager{
+		host:         host,
+		port:         port,
+		udpPort:      DefaultUDPPort,
+		source:       SourceAuto,
+		resolver:     NewResolver(host, port),
+		staleTimeout: DefaultStaleTimeout,
+	}
+	m.Manager = sim.NewManager("X-Plane", m.dial)
+	return m
+}
+
+// SetSource chooses how to talk to X-Plane: SourceAuto, SourceWebAPI or
+// SourceUDP, with udpPort used for UDP. Call before Run.
+func (m *Manager) SetSource(source string, udpPort int) {
+	m.source = source
+	m.udpPort = udpPort
+}
+
+// SetResolver shares resolver's cached dataref IDs with the manager's
+// connections, so a new manager for the same X-Plane skips the lookup. Call
+// before Run.
+func (m *Manager) SetResolver(resolver *Resolver) {
+	m.resolver = resolver
+}
+
+// SetDebug enables logging of every WebSocket message. Call before Run.
+func (m *Manager) SetDebug(debug bool) {
+	m.debug = debug
+}
+
+// SetCapture records everything X-Plane sends over the Web API to w; see
+// Client.SetCapture. UDP connections aren't captured. Call before Run.
+func (m *Manager) SetCapture(w io.Writer) {
+	m.capture = newCapture(w)
+}
+
+// SetStaleTimeout sets how long a connection may go without dataref updates
+// before it is dropped and reconnected. 0 disables the watchdog. Call before
+// Run.
+func (m *Manager) SetStaleTimeout(d time.Duration) {
+	m.staleTimeout = d
+}
+
+// dial connects using the configured source
+func (m *Manager) dial(ctx context.Context) (sim.FlightSource, error) {
+	switch m.source {
+	case SourceWebAPI:
+		return m.dialWebAPI(ctx)
+	case SourceUDP:
+		return m.dialUDP(ctx)
+	}
+
+	// Older X-Plane builds, or the web server turned off. If the Web API
+	// answered at all it's there and the error is worth retrying.
+	c, err := m.dialWebAPI(ctx)
+	var urlErr *url.Error
+	if err == nil || ctx.Err() != nil || !errors.As(err, &urlErr) {
+		return c, err
+	}
+	u, udpErr := m.dialUDP(ctx)
+	if udpErr != nil {
+		return nil, fmt.Errorf("%w (UDP: %v)", err, udpErr)
+	}
+	log.Printf("Web API unavailable (%v), using UDP", err)
+	return u, nil
+}
+
+func (m *Manager) dialWebAPI(ctx context.Context) (sim.FlightSource, error) {
+	c := NewClient(m.host, m.port)
+	c.SetResolver(m.resolver)
+	c.SetDebug(m.debug)
+	c.capture = m.capture
+	c.SetStaleTimeout(m.staleTimeout)
+	if err := c.ConnectContext(ctx); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (m *Manager) dialUDP(ctx context.Context) (sim.FlightSource, error) {
+	c := NewUDPClient(m.host, m.udpPort)
+	c.SetStaleTimeout(m.staleTimeout)
+	if err := c.ConnectContext(ctx); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
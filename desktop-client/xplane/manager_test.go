@@ -0,0 +1,124 @@
+package xplane_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/bushtalkradio/xplane-client/sim"
+	"github.com/bushtalkradio/xplane-client/xplane"
+)
+
+// startManager runs a manager against port until the end of the test
+func startManager(t *testing.T, port int) *xplane.Manager {
+	t.Helper()
+	m := xplane.NewManager(xplane.DefaultHost, port)
+	m.SetReconnectDelay(10 * time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go m.Run(ctx)
+	t.Cleanup(func() {
+		cancel()
+		<-m.Done()
+	})
+	return m
+}
+
+// nextUpdate waits for the manager's next published snapshot
+func nextUpdate(t *testing.T, m *xplane.Manager) sim.Snapshot {
+	t.Helper()
+	select {
+	case snap := <-m.Updates():
+		return snap
+	case <-time.After(waitTimeout):
+		t.Fatal("timed out waiting for an update")
+	}
+	return sim.Snapshot{}
+}
+
+func TestManagerConnects(t *testing.T) {
+	s := newServer(t)
+	m := startManager(t, s.Port())
+
+	if snap := nextUpdate(t, m); !snap.Connected || snap.Capabilities.Name != "X-Plane Web API" {
+		t.Fatalf("first update = %+v, want connected to the Web API", snap)
+	}
+	waitFor(t, "position", func() bool {
+		return m.Snapshot(context.Background()).Position.TailNumber == "N185BT"
+	})
+}
+
+func TestManagerReconnects(t *testing.T) {
+	s := newServer(t)
+	m := startManager(t, s.Port())
+
+	if snap := nextUpdate(t, m); !snap.Connected {
+		t.Fatalf("first update = %+v, want connected", snap)
+	}
+
+	s.DropConnections()
+	if snap := nextUpdate(t, m); snap.Connected {
+		t.Fatal("no disconnected update after X-Plane dropped the connection")
+	}
+	if snap := nextUpdate(t, m); !snap.Connected {
+		t.Fatalf("update = %+v, want reconnected", snap)
+	}
+}
+
+func TestManagerRetriesFailedConnection(t *testing.T) {
+	s := newServer(t)
+	s.FailLookups(http.StatusServiceUnavailable)
+	m := startManager(t, s.Port())
+
+	snap := nextUpdate(t, m)
+	if snap.Connected || snap.Err == nil {
+		t.Fatalf("update = %+v, want a connection error", snap)
+	}
+
+	s.FailLookups(0)
+	waitFor(t, "connection", func() bool { return m.Snapshot(context.Background()).Connected })
+}
+
+func TestManagerSnapshotAfterStop(t *testing.T) {
+	s := newServer(t)
+	m := xplane.NewManager(xplane.DefaultHost, s.Port())
+	ctx, cancel := context.WithCancel(context.Background())
+	go m.Run(ctx)
+	nextUpdate(t, m)
+
+	cancel()
+	<-m.Done()
+	if snap := m.Snapshot(context.Background()); snap.Connected {
+		t.Error("Snapshot() reports connected after Run returned")
+	}
+}
+
+// Many goroutines reading while the connection drops and comes back; run
+// with -race
+func TestManagerConcurrentSnapshots(t *testing.T) {
+	s := newServer(t)
+	m := startManager(t, s.Port())
+	nextUpdate(t, m)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	for i := 0; i < 8; i++ {
+		go func() {
+			defer func() { done <- struct{}{} }()
+			for ctx.Err() == nil {
+				m.Snapshot(ctx)
+			}
+		}()
+	}
+
+	for i := 0; i < 3; i++ {
+		s.DropConnections()
+		time.Sleep(20 * time.Millisecond)
+	}
+	cancel()
+	for i := 0; i < 8; i++ {
+		<-done
+	}
+}
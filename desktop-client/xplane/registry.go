@@ -0,0 +1,210 @@
+package xplane
+
+import (
+	"encoding/json"
+	"strconv"
+	"sync"
+)
+
+// ValueType describes how the Web API encodes a dataref's value
+type ValueType int
+
+const (
+	TypeFloat        ValueType = iota // float or double
+	TypeInt                           // int, sent as a JSON number
+	TypeArrayElement                  // one element of a float or int array
+	TypeByteString                    // byte array holding a null-terminated string
+)
+
+// Dataref declares a dataref to subscribe to and how its value is stored in Position
+type Dataref struct {
+	Name     string
+	Type     ValueType
+	Index    int  // array element for TypeArrayElement
+	Required bool // tracking can't work without it
+
+	setFloat  func(p *Position, v float64)
+	setInt    func(p *Position, v int)
+	setString func(p *Position, v string)
+}
+
+// Float declares a float or double dataref
+func Float(name string, set func(p *Position, v float64)) *Dataref {
+	return &Dataref{Name: name, Type: TypeFloat, setFloat: set}
+}
+
+// Int declares an int dataref
+func Int(name string, set func(p *Position, v int)) *Dataref {
+	return &Dataref{Name: name, Type: TypeInt, setInt: set}
+}
+
+// ArrayElement declares a single element of an array dataref
+func ArrayElement(name string, index int, set func(p *Position, v float64)) *Dataref {
+	return &Dataref{Name: name, Type: TypeArrayElement, Index: index, setFloat: set}
+}
+
+// ByteString declares a byte array dataref holding a string, such as the tail number
+func ByteString(name string, set func(p *Position, v string)) *Dataref {
+	return &Dataref{Name: name, Type: TypeByteString, setString: set}
+}
+
+// AsRequired marks the dataref as required and returns it
+func (d *Dataref) AsRequired() *Dataref {
+	d.Required = true
+	return d
+}
+
+// Apply stores an already decoded value in p: a float64 for numbers, a
+// []interface{} for arrays, or a string/[]interface{} for byte strings.
+// Values of the wrong type are ignored.
+func (d *Dataref) Apply(p *Position, value interface{}) {
+	switch d.Type {
+	case TypeFloat:
+		if v, ok := value.(float64); ok {
+			d.setFloat(p, v)
+		}
+	case TypeInt:
+		if v, ok := value.(float64); ok {
+			d.setInt(p, int(v))
+		}
+	case TypeArrayElement:
+		values, ok := value.([]interface{})
+		if !ok || d.Index >= len(values) {
+			return
+		}
+		if v, ok := values[d.Index].(float64); ok {
+			d.setFloat(p, v)
+		}
+	case TypeByteString:
+		d.setString(p, DecodeByteString(value))
+	}
+}
+
+// ApplyJSON decodes a raw JSON value from the Web API and stores it in p.
+// Scalars are parsed directly without going through interface{}, which
+// keeps the WebSocket read loop cheap. Malformed values are ignored.
+func (d *Dataref) ApplyJSON(p *Position, raw []byte) {
+	switch d.Type {
+	case TypeFloat:
+		if v, err := strconv.ParseFloat(string(raw), 64); err == nil {
+			d.setFloat(p, v)
+		}
+	case TypeInt:
+		if v, err := strconv.ParseFloat(string(raw), 64); err == nil {
+			d.setInt(p, int(v))
+		}
+	case TypeArrayElement:
+		var values []float64
+		if err := json.Unmarshal(raw, &values); err == nil && d.Index < len(values) {
+			d.setFloat(p, values[d.Index])
+		}
+	case TypeByteString:
+		var value interface{}
+		if err := json.Unmarshal(raw, &value); err == nil {
+			d.setString(p, DecodeByteString(value))
+		}
+	}
+}
+
+// ApplyNumber stores a single number in p, as the UDP protocol delivers
+// them: the value itself, or the requested element for arrays. Byte strings
+// arrive a byte at a time and go through ApplyBytes instead.
+func (d *Dataref) ApplyNumber(p *Position, v float64) {
+	switch d.Type {
+	case TypeFloat, TypeArrayElement:
+		d.setFloat(p, v)
+	case TypeInt:
+		d.setInt(p, int(v))
+	}
+}
+
+// ApplyBytes stores a byte string collected element by element
+func (d *Dataref) ApplyBytes(p *Position, b []byte) {
+	if d.Type == TypeByteString {
+		d.setString(p, cleanByteString(string(b)))
+	}
+}
+
+// Registry is the set of datarefs a client subscribes to. Several entries
+// may share a name, e.g. different elements of the same array.
+type Registry struct {
+	datarefs []*Dataref
+	mu       sync.RWMutex
+}
+
+// NewRegistry creates a registry holding the given datarefs
+func NewRegistry(datarefs ...*Dataref) *Registry {
+	return &Registry{datarefs: datarefs}
+}
+
+// Register adds datarefs to the registry. Clients pick them up on their next Connect.
+func (r *Registry) Register(datarefs ...*Dataref) {
+	r.mu.Lock()
+	r.datarefs = append(r.datarefs, datarefs...)
+	r.mu.Unlock()
+}
+
+// Datarefs returns all registered datarefs
+func (r *Registry) Datarefs() []*Dataref {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]*Dataref(nil), r.datarefs...)
+}
+
+// Names returns the distinct dataref names to resolve and subscribe to
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	seen := make(map[string]bool)
+	var names []string
+	for _, d := range r.datarefs {
+		if !seen[d.Name] {
+			seen[d.Name] = true
+			names = append(names, d.Name)
+		}
+	}
+	return names
+}
+
+// RequiredNames returns the names of datarefs that must exist
+func (r *Registry) RequiredNames() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var names []string
+	for _, d := range r.datarefs {
+		if d.Required {
+			names = append(names, d.Name)
+		}
+	}
+	return names
+}
+
+// DefaultRegistry holds the datarefs used for flight tracking
+var DefaultRegistry = NewRegistry(
+	Float(DatarefLatitude, func(p *Position, v float64) { p.Latitude = v }).AsRequired(),
+	Float(DatarefLongitude, func(p *Position, v float64) { p.Longitude = v }).AsRequired(),
+	Float(DatarefAltitudeAGL, func(p *Position, v float64) { p.AltitudeAGL = v }),
+	Float(DatarefGroundspeed, func(p *Position, v float64) { p.Groundspeed = v }),
+	Float(DatarefHeading, func(p *Position, v float64) { p.Heading = v }),
+	ByteString(DatarefTailNum, func(p *Position, v string) { p.TailNumber = orUnknown(v) }),
+	ByteString(DatarefICAO, func(p *Position, v string) { p.AircraftICAO = v }),
+	ByteString(DatarefDescription, func(p *Position, v string) { p.AircraftDescription = v }),
+	ByteString(DatarefAuthor, func(p *Position, v string) { p.AircraftAuthor = v }),
+	ByteString(DatarefLiveryPath, func(p *Position, v string) { p.Livery = LiveryName(v) }),
+	Float(DatarefAltitudeMSL, func(p *Position, v float64) { p.AltitudeMSL = v }),
+	Float(DatarefIAS, func(p *Position, v float64) { p.IndicatedAirspeed = v }),
+	Float(DatarefVS, func(p *Position, v float64) { p.VerticalSpeed = v }),
+	Float(DatarefTrueHeading, func(p *Position, v float64) { p.TrueHeading = v }),
+	Float(DatarefTrack, func(p *Position, v float64) { p.Track = v }),
+	Float(DatarefPitch, func(p *Position, v float64) { p.Pitch = v }),
+	Float(DatarefRoll, func(p *Position, v float64) { p.Roll = v }),
+	Int(DatarefOnGround, func(p *Position, v int) { p.OnGround, p.HasOnGround = v != 0, true }),
+	// Heartbeat: changes every frame, so updates keep arriving while parked
+	Float(DatarefRunningTime, func(p *Position, v float64) {}),
+	Int(DatarefPaused, func(p *Position, v int) { p.Paused = v != 0 }),
+	Int(DatarefReplayMode, func(p *Position, v int) { p.Replay = v != 0 }),
+	Int(DatarefSimSpeed, func(p *Position, v int) { p.SimSpeed = v }),
+	Int(DatarefGroundSpeed, func(p *Position, v int) { p.TimeCompression = v }),
+)
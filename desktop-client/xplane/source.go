@@ -0,0 +1,19 @@
+package xplane
+
+import "github.com/bushtalkradio/xplane-client/sim"
+
+// DefaultHost is where X-Plane runs unless told otherwise
+const DefaultHost = "localhost"
+
+// Source kinds, as set in config.json's "xplane_source". Client uses the
+// Web API and UDPClient the UDP RREF protocol.
+const (
+	SourceAuto   = "auto"   // Web API, falling back to UDP if it can't be reached
+	SourceWebAPI = "webapi" // Web API only (X-Plane 12.1.1 and later)
+	SourceUDP    = "udp"    // UDP RREF only
+)
+
+var (
+	_ sim.FlightSource = (*Client)(nil)
+	_ sim.FlightSource = (*UDPClient)(nil)
+)
@@ -0,0 +1,285 @@
+package xplane
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/bushtalkradio/xplane-client/sim"
+)
+
+// DefaultUDPPort is X-Plane's UDP listening port
+const DefaultUDPPort = 49000
+
+const (
+	// rrefFrequency is how many times a second X-Plane sends each dataref
+	rrefFrequency = 5
+	// rrefNameSize is the fixed size of the dataref name in an RREF request
+	rrefNameSize = 400
+	// udpStringLength is how many bytes of each byte string dataref we
+	// request; every byte is a separate subscription
+	udpStringLength = 40
+	// udpFirstReply is how long to wait for X-Plane to start sending
+	udpFirstReply = 3 * time.Second
+	// udpResubscribe is how often requests X-Plane hasn't answered are sent
+	// again, as a burst of them can overflow its receive buffer
+	udpResubscribe = time.Second
+)
+
+// udpSkip lists datarefs that are useless when cut to udpStringLength
+var udpSkip = map[string]bool{
+	DatarefLiveryPath: true, // the livery name is at the end of the path
+}
+
+// rrefSub is one RREF subscription: a number, or one byte of a string
+type rrefSub struct {
+	dataref *Dataref
+	offset  int // byte offset for byte strings
+}
+
+// UDPClient reads datarefs over X-Plane's UDP RREF protocol. It works with
+// X-Plane builds that predate the Web API, or with the web server disabled.
+// Byte strings longer than 40 characters are truncated, and the livery isn't
+// available.
+type UDPClient struct {
+	host         string
+	port         int
+	registry     *Registry
+	staleTimeout time.Duration
+
+	udp     *sim.UDPListener
+	conn    *net.UDPConn
+	addr    *net.UDPAddr
+	subs    []rrefSub           // indexed by RREF index
+	seen    []bool              // X-Plane has answered subs[i]; guarded by positionMu
+	strings map[*Dataref][]byte // byte strings being collected
+
+	position   Position
+	positionMu sync.RWMutex
+}
+
+// NewUDPClient creates a client for X-Plane's UDP port at host
+func NewUDPClient(host string, port int) *UDPClient {
+	return &UDPClient{
+		host:         host,
+		port:         port,
+		registry:     DefaultRegistry,
+		staleTimeout: DefaultStaleTimeout,
+		udp:          sim.NewUDPListener("X-Plane"),
+	}
+}
+
+// SetRegistry replaces the datarefs to subscribe to. Call before Connect.
+func (c *UDPClient) SetRegistry(registry *Registry) {
+	c.registry = registry
+}
+
+// SetStaleTimeout sets how long without packets before the connection is
+// treated as lost. 0 uses readTimeout. Call before Connect.
+func (c *UDPClient) SetStaleTimeout(d time.Duration) {
+	c.staleTimeout = d
+}
+
+// Done returns a channel that's closed when the connection is lost
+func (c *UDPClient) Done() <-chan struct{} {
+	return c.udp.Done()
+}
+
+// ConnectContext subscribes to the registry's datarefs and waits for X-Plane
+// to start sending them
+func (c *UDPClient) ConnectContext(ctx context.Context) error {
+	addr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(c.host, fmt.Sprint(c.port)))
+	if err != nil {
+		return fmt.Errorf("failed to resolve X-Plane address: %w", err)
+	}
+
+	// Built before conn is opened, as Disconnect unsubscribes from them
+	c.subs = nil
+	c.strings = make(map[*Dataref][]byte)
+	for _, d := range c.registry.Datarefs() {
+		switch {
+		case udpSkip[d.Name]:
+		case d.Type == TypeByteString:
+			c.strings[d] = make([]byte, udpStringLength)
+			for i := 0; i < udpStringLength; i++ {
+				c.subs = append(c.subs, rrefSub{dataref: d, offset: i})
+			}
+		default:
+			c.subs = append(c.subs, rrefSub{dataref: d})
+		}
+	}
+	c.seen = make([]bool, len(c.subs))
+
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return fmt.Errorf("failed to open UDP socket: %w", err)
+	}
+	c.conn, c.addr = conn, addr
+	if err := c.udp.Open(conn); err != nil {
+		return err
+	}
+	if err := c.subscribe(rrefFrequency); err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to subscribe: %w", err)
+	}
+
+	// UDP has no handshake; X-Plane is there once values arrive
+	err = c.udp.Await(ctx, udpFirstReply, func(packet []byte) (bool, error) {
+		return c.handlePacket(packet), nil
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return err
+		}
+		return fmt.Errorf("no reply from X-Plane on UDP port %d: %w", c.port, err)
+	}
+
+	timeout := c.staleTimeout
+	if timeout <= 0 {
+		timeout = readTimeout
+	}
+	lastResubscribe := time.Now()
+	c.udp.Serve(timeout, func(packet []byte) {
+		c.handlePacket(packet)
+		if time.Since(lastResubscribe) >= udpResubscribe {
+			lastResubscribe = time.Now()
+			if err := c.resubscribeMissing(); err != nil {
+				log.Printf("UDP subscribe error: %v", err)
+			}
+		}
+	})
+	return nil
+}
+
+// subscribe sends an RREF request for every subscription; frequency 0
+// unsubscribes
+func (c *UDPClient) subscribe(frequency int) error {
+	for i := range c.subs {
+		if err := c.request(frequency, i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resubscribeMissing repeats the requests X-Plane hasn't answered yet
+func (c *UDPClient) resubscribeMissing() error {
+	c.positionMu.RLock()
+	var missing []int
+	for i, seen := range c.seen {
+		if !seen {
+			missing = append(missing, i)
+		}
+	}
+	c.positionMu.RUnlock()
+
+	for _, i := range missing {
+		if err := c.request(rrefFrequency, i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// request sends the RREF request for subscription i
+func (c *UDPClient) request(frequency, i int) error {
+	sub := c.subs[i]
+	name := sub.dataref.Name
+	switch {
+	case sub.dataref.Type == TypeByteString:
+		name = fmt.Sprintf("%s[%d]", name, sub.offset)
+	case sub.dataref.Type == TypeArrayElement:
+		name = fmt.Sprintf("%s[%d]", name, sub.dataref.Index)
+	}
+	_, err := c.conn.WriteToUDP(rrefRequest(frequency, i, name), c.addr)
+	return err
+}
+
+// rrefRequest encodes an RREF request: "RREF\0", frequency, index and a
+// null-padded dataref name
+func rrefRequest(frequency, index int, name string) []byte {
+	msg := make([]byte, 5+4+4+rrefNameSize)
+	copy(msg, "RREF")
+	binary.LittleEndian.PutUint32(msg[5:], uint32(frequency))
+	binary.LittleEndian.PutUint32(msg[9:], uint32(index))
+	copy(msg[13:13+rrefNameSize-1], name)
+	return msg
+}
+
+// handlePacket applies an RREF reply: "RREF" plus one byte, then pairs of
+// little-endian int32 index and float32 value. It reports whether the
+// packet was an RREF reply.
+func (c *UDPClient) handlePacket(packet []byte) bool {
+	if len(packet) < 5 || !bytes.Equal(packet[:4], []byte("RREF")) {
+		return false
+	}
+
+	c.positionMu.Lock()
+	defer c.positionMu.Unlock()
+
+	sawString := false
+	for data := packet[5:]; len(data) >= 8; data = data[8:] {
+		index := int(int32(binary.LittleEndian.Uint32(data)))
+		value := math.Float32frombits(binary.LittleEndian.Uint32(data[4:]))
+		if index < 0 || index >= len(c.subs) {
+			continue
+		}
+		c.seen[index] = true
+		sub := c.subs[index]
+		if sub.dataref.Type == TypeByteString {
+			c.strings[sub.dataref][sub.offset] = byte(value)
+			sawString = true
+			continue
+		}
+		sub.dataref.ApplyNumber(&c.position, float64(value))
+	}
+	if sawString {
+		for d, b := range c.strings {
+			d.ApplyBytes(&c.position, b)
+		}
+	}
+	c.position.Timestamp = time.Now()
+	return true
+}
+
+// GetPosition returns the current position (thread-safe)
+func (c *UDPClient) GetPosition() Position {
+	c.positionMu.RLock()
+	defer c.positionMu.RUnlock()
+	return c.position
+}
+
+// Snapshot returns the current position; see sim.FlightSource
+func (c *UDPClient) Snapshot() Position {
+	return c.GetPosition()
+}
+
+// Capabilities reports everything but the livery, which UDP can't carry
+func (c *UDPClient) Capabilities() sim.Capabilities {
+	return sim.Capabilities{
+		Name:          "X-Plane UDP",
+		OnGround:      true,
+		SimState:      true,
+		AircraftType:  true,
+		AircraftTitle: true,
+		FlightState:   true,
+	}
+}
+
+// IsConnected returns true while X-Plane is sending data
+func (c *UDPClient) IsConnected() bool {
+	return c.udp.IsConnected()
+}
+
+// Disconnect unsubscribes and closes the socket. It is safe to call more
+// than once and from any goroutine.
+func (c *UDPClient) Disconnect() {
+	// Otherwise X-Plane keeps sending to a closed port
+	c.udp.Close(func() { c.subscribe(0) })
+}
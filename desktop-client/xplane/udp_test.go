@@ -0,0 +1,127 @@
+package xplane_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bushtalkradio/xplane-client/xplane"
+	"github.com/bushtalkradio/xplane-client/xplane/xplanetest"
+)
+
+// newUDPServer starts a fake X-Plane UDP interface with a position set
+func newUDPServer(t *testing.T) *xplanetest.UDPServer {
+	t.Helper()
+	s := xplanetest.NewUDPServer()
+	t.Cleanup(s.Close)
+
+	s.SetValue(xplane.DatarefLatitude, 61.2176)
+	s.SetValue(xplane.DatarefLongitude, -149.8997)
+	s.SetValue(xplane.DatarefAltitudeAGL, 304.8)
+	s.SetValue(xplane.DatarefHeading, 92.5)
+	s.SetValue(xplane.DatarefOnGround, 1)
+	s.SetString(xplane.DatarefTailNum, "N185BT")
+	s.SetString(xplane.DatarefICAO, "C185")
+	return s
+}
+
+func TestUDPClientReceivesPosition(t *testing.T) {
+	s := newUDPServer(t)
+	c := xplane.NewUDPClient(xplane.DefaultHost, s.Port())
+	if err := c.ConnectContext(context.Background()); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	defer c.Disconnect()
+
+	waitFor(t, "position", func() bool {
+		pos := c.GetPosition()
+		return pos.TailNumber == "N185BT" && pos.AircraftICAO == "C185" && pos.HasOnGround
+	})
+	pos := c.GetPosition()
+	// Values travel as float32
+	if float32(pos.Latitude) != float32(61.2176) || float32(pos.Longitude) != float32(-149.8997) {
+		t.Errorf("position = %v, %v", pos.Latitude, pos.Longitude)
+	}
+	if float32(pos.Heading) != 92.5 || !pos.IsOnGround() {
+		t.Errorf("heading/on ground = %v/%v", pos.Heading, pos.IsOnGround())
+	}
+	if pos.AircraftICAO != "C185" {
+		t.Errorf("AircraftICAO = %q, want C185", pos.AircraftICAO)
+	}
+}
+
+func TestUDPClientUnsubscribesOnDisconnect(t *testing.T) {
+	s := newUDPServer(t)
+	c := xplane.NewUDPClient(xplane.DefaultHost, s.Port())
+	if err := c.ConnectContext(context.Background()); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+
+	c.Disconnect()
+	c.Disconnect()
+	waitFor(t, "unsubscribe", func() bool { return s.Subscriptions() == 0 })
+}
+
+func TestUDPClientDisconnectWhileConnecting(t *testing.T) {
+	s := newUDPServer(t)
+	s.SetSilent(true)
+
+	c := xplane.NewUDPClient(xplane.DefaultHost, s.Port())
+	errc := make(chan error, 1)
+	go func() { errc <- c.ConnectContext(context.Background()) }()
+	c.Disconnect()
+	if err := <-errc; err == nil {
+		t.Fatal("Connect succeeded after Disconnect")
+	}
+}
+
+func TestUDPClientNoReply(t *testing.T) {
+	s := newUDPServer(t)
+	s.SetSilent(true)
+
+	c := xplane.NewUDPClient(xplane.DefaultHost, s.Port())
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if err := c.ConnectContext(ctx); err == nil {
+		c.Disconnect()
+		t.Fatal("Connect succeeded without X-Plane replying")
+	}
+}
+
+func TestUDPClientLost(t *testing.T) {
+	s := newUDPServer(t)
+	c := xplane.NewUDPClient(xplane.DefaultHost, s.Port())
+	c.SetStaleTimeout(100 * time.Millisecond)
+	if err := c.ConnectContext(context.Background()); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	defer c.Disconnect()
+
+	s.SetSilent(true)
+	select {
+	case <-c.Done():
+	case <-time.After(waitTimeout):
+		t.Fatal("Done not closed after X-Plane went quiet")
+	}
+}
+
+func TestManagerFallsBackToUDP(t *testing.T) {
+	// No Web API on the port, but UDP answers
+	web := newServer(t)
+	port := web.Port()
+	web.Close()
+	udp := newUDPServer(t)
+
+	m := xplane.NewManager(xplane.DefaultHost, port)
+	m.SetSource(xplane.SourceAuto, udp.Port())
+	ctx, cancel := context.WithCancel(context.Background())
+	go m.Run(ctx)
+	defer func() {
+		cancel()
+		<-m.Done()
+	}()
+
+	if snap := nextUpdate(t, m); !snap.Connected || snap.Capabilities.Name != "X-Plane UDP" {
+		t.Fatalf("update = %+v, want connected over UDP", snap)
+	}
+}
@@ -0,0 +1,316 @@
+// Package xplanetest provides in-process fakes of the X-Plane 12 Web API and
+// UDP interface for tests and offline development.
+package xplanetest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Server fakes the REST dataref lookup (/api/v3/datarefs) and the WebSocket
+// (/api/v3) with dataref_subscribe_values and value pushes. Datarefs and
+// their values are scripted with AddDataref and SetValue.
+type Server struct {
+	srv      *httptest.Server
+	upgrader websocket.Upgrader
+
+	mu               sync.Mutex
+	datarefs         map[string]*dataref
+	nextID           int64
+	conns            map[*conn]bool
+	lookups          int
+	lookupStatus     int  // non-zero makes lookups fail with this status
+	rejectSubscribes bool // answer subscriptions with success=false
+	subscribed       chan struct{}
+}
+
+type dataref struct {
+	id        int64
+	name      string
+	valueType string
+	value     interface{}
+}
+
+// conn is a connected WebSocket client and the IDs it subscribed to
+type conn struct {
+	ws      *websocket.Conn
+	writeMu sync.Mutex
+	ids     map[int64]bool
+}
+
+// NewServer starts a fake X-Plane listening on a random local port
+func NewServer() *Server {
+	s := &Server{
+		datarefs:   make(map[string]*dataref),
+		nextID:     1000,
+		conns:      make(map[*conn]bool),
+		subscribed: make(chan struct{}, 16),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v3/datarefs", s.handleDatarefs)
+	mux.HandleFunc("/api/v3", s.handleWebSocket)
+	s.srv = httptest.NewServer(mux)
+	return s
+}
+
+// Port returns the port to pass to xplane.NewClient
+func (s *Server) Port() int {
+	u, _ := url.Parse(s.srv.URL)
+	port, _ := strconv.Atoi(u.Port())
+	return port
+}
+
+// Close drops all connections and shuts the server down
+func (s *Server) Close() {
+	s.DropConnections()
+	s.srv.Close()
+}
+
+// AddDataref makes a dataref available with an initial value and returns its ID.
+// valueType is the Web API type name, e.g. "double", "int" or "data".
+func (s *Server) AddDataref(name, valueType string, value interface{}) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextID++
+	s.datarefs[name] = &dataref{
+		id:        s.nextID,
+		name:      name,
+		valueType: valueType,
+		value:     value,
+	}
+	return s.nextID
+}
+
+// SetValue changes a dataref's value and pushes it to subscribed clients
+func (s *Server) SetValue(name string, value interface{}) {
+	s.mu.Lock()
+	d, ok := s.datarefs[name]
+	if !ok {
+		s.mu.Unlock()
+		panic("xplanetest: unknown dataref " + name)
+	}
+	d.value = value
+	s.mu.Unlock()
+
+	s.push(map[int64]interface{}{d.id: value})
+}
+
+// Push sends the current value of every subscribed dataref, like X-Plane's periodic update
+func (s *Server) Push() {
+	s.mu.Lock()
+	values := make(map[int64]interface{})
+	for _, d := range s.datarefs {
+		values[d.id] = d.value
+	}
+	s.mu.Unlock()
+
+	s.push(values)
+}
+
+// Renumber gives every dataref a new ID, as happens when X-Plane restarts
+func (s *Server) Renumber() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, d := range s.datarefs {
+		s.nextID++
+		d.id = s.nextID
+	}
+}
+
+// DropConnections closes all WebSocket connections without a close handshake
+func (s *Server) DropConnections() {
+	s.mu.Lock()
+	conns := s.conns
+	s.conns = make(map[*conn]bool)
+	s.mu.Unlock()
+
+	for c := range conns {
+		c.ws.Close()
+	}
+}
+
+// FailLookups makes REST lookups respond with status; 0 restores normal behaviour
+func (s *Server) FailLookups(status int) {
+	s.mu.Lock()
+	s.lookupStatus = status
+	s.mu.Unlock()
+}
+
+// RejectSubscriptions makes subscription requests fail, as X-Plane does for unknown IDs
+func (s *Server) RejectSubscriptions(reject bool) {
+	s.mu.Lock()
+	s.rejectSubscribes = reject
+	s.mu.Unlock()
+}
+
+// Lookups returns how many REST lookup requests were served
+func (s *Server) Lookups() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lookups
+}
+
+// WaitSubscribed waits until a client has sent a subscription request
+func (s *Server) WaitSubscribed(timeout time.Duration) error {
+	select {
+	case <-s.subscribed:
+		return nil
+	case <-time.After(timeout):
+		return fmt.Errorf("no subscription within %v", timeout)
+	}
+}
+
+// handleDatarefs serves GET /api/v3/datarefs?filter[name]=...
+func (s *Server) handleDatarefs(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lookups++
+	if s.lookupStatus != 0 {
+		http.Error(w, `{"error_code":"test_failure"}`, s.lookupStatus)
+		return
+	}
+
+	type info struct {
+		ID        int64  `json:"id"`
+		Name      string `json:"name"`
+		ValueType string `json:"value_type"`
+	}
+	data := []info{}
+	for _, name := range r.URL.Query()["filter[name]"] {
+		if d, ok := s.datarefs[name]; ok {
+			data = append(data, info{ID: d.id, Name: d.name, ValueType: d.valueType})
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+}
+
+// handleWebSocket serves the /api/v3 WebSocket
+func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	ws, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+
+	c := &conn{ws: ws, ids: make(map[int64]bool)}
+	s.mu.Lock()
+	s.conns[c] = true
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+		ws.Close()
+	}()
+
+	for {
+		var msg struct {
+			ReqID  int    `json:"req_id"`
+			Type   string `json:"type"`
+			Params struct {
+				Datarefs []struct {
+					ID int64 `json:"id"`
+				} `json:"datarefs"`
+			} `json:"params"`
+		}
+		if err := ws.ReadJSON(&msg); err != nil {
+			return
+		}
+
+		if msg.Type != "dataref_subscribe_values" {
+			c.write(map[string]interface{}{"req_id": msg.ReqID, "type": "result", "success": false,
+				"error_code": "unknown_type", "error_message": "unsupported request type"})
+			continue
+		}
+
+		s.mu.Lock()
+		reject := s.rejectSubscribes
+		known := make(map[int64]interface{})
+		for _, d := range s.datarefs {
+			known[d.id] = d.value
+		}
+		s.mu.Unlock()
+
+		values := make(map[int64]interface{})
+		for _, sub := range msg.Params.Datarefs {
+			value, ok := known[sub.ID]
+			if !ok {
+				reject = true
+				break
+			}
+			values[sub.ID] = value
+		}
+
+		if reject {
+			c.write(map[string]interface{}{"req_id": msg.ReqID, "type": "result", "success": false,
+				"error_code": "invalid_dataref_id", "error_message": "dataref id not found"})
+		} else {
+			s.mu.Lock()
+			for id := range values {
+				c.ids[id] = true
+			}
+			s.mu.Unlock()
+			c.write(map[string]interface{}{"req_id": msg.ReqID, "type": "result", "success": true})
+			c.writeValues(values)
+		}
+
+		select {
+		case s.subscribed <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// push sends values to every connection subscribed to them
+func (s *Server) push(values map[int64]interface{}) {
+	s.mu.Lock()
+	type update struct {
+		c      *conn
+		values map[int64]interface{}
+	}
+	var updates []update
+	for c := range s.conns {
+		subset := make(map[int64]interface{})
+		for id, value := range values {
+			if c.ids[id] {
+				subset[id] = value
+			}
+		}
+		if len(subset) > 0 {
+			updates = append(updates, update{c, subset})
+		}
+	}
+	s.mu.Unlock()
+
+	for _, u := range updates {
+		u.c.writeValues(u.values)
+	}
+}
+
+// writeValues sends a dataref_update_values message keyed by ID strings
+func (c *conn) writeValues(values map[int64]interface{}) {
+	data := make(map[string]interface{}, len(values))
+	for id, value := range values {
+		data[strconv.FormatInt(id, 10)] = value
+	}
+	c.write(map[string]interface{}{"type": "dataref_update_values", "data": data})
+}
+
+func (c *conn) write(msg interface{}) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.ws.WriteJSON(msg)
+}
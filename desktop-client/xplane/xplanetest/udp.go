@@ -0,0 +1,193 @@
+package xplanetest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net"
+	"sync"
+	"time"
+)
+
+// udpSendInterval is how often UDPServer sends subscribed values
+const udpSendInterval = 20 * time.Millisecond
+
+// UDPServer fakes X-Plane's UDP RREF interface: clients subscribe to
+// datarefs by name, then receive their values until they unsubscribe.
+// Unknown datarefs read as 0, as in X-Plane.
+type UDPServer struct {
+	conn *net.UDPConn
+
+	mu     sync.Mutex
+	values map[string]float32          // by name, "name[i]" for elements
+	subs   map[string]map[int32]string // by client address, then index
+	addrs  map[string]*net.UDPAddr
+	silent bool // stop sending, like a frozen X-Plane
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// NewUDPServer starts a fake X-Plane UDP interface on a random local port
+func NewUDPServer() *UDPServer {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		panic(fmt.Sprintf("xplanetest: failed to listen: %v", err))
+	}
+	// Subscribing sends a burst of requests, one per dataref
+	conn.SetReadBuffer(1 << 20)
+
+	s := &UDPServer{
+		conn:   conn,
+		values: make(map[string]float32),
+		subs:   make(map[string]map[int32]string),
+		addrs:  make(map[string]*net.UDPAddr),
+		done:   make(chan struct{}),
+	}
+	s.wg.Add(2)
+	go s.readLoop()
+	go s.sendLoop()
+	return s
+}
+
+// Port returns the UDP port to point the client at
+func (s *UDPServer) Port() int {
+	return s.conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+// Close stops the server
+func (s *UDPServer) Close() {
+	close(s.done)
+	s.conn.Close()
+	s.wg.Wait()
+}
+
+// SetValue sets a numeric dataref, or one element with "name[i]"
+func (s *UDPServer) SetValue(name string, value float32) {
+	s.mu.Lock()
+	s.values[name] = value
+	s.mu.Unlock()
+}
+
+// SetString sets a byte array dataref to a null-terminated string
+func (s *UDPServer) SetString(name, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 0; i <= len(value); i++ {
+		var b byte
+		if i < len(value) {
+			b = value[i]
+		}
+		s.values[fmt.Sprintf("%s[%d]", name, i)] = float32(b)
+	}
+}
+
+// SetSilent stops or resumes sending values
+func (s *UDPServer) SetSilent(silent bool) {
+	s.mu.Lock()
+	s.silent = silent
+	s.mu.Unlock()
+}
+
+// Subscriptions returns the number of active subscriptions across clients
+func (s *UDPServer) Subscriptions() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, subs := range s.subs {
+		n += len(subs)
+	}
+	return n
+}
+
+// readLoop handles RREF requests
+func (s *UDPServer) readLoop() {
+	defer s.wg.Done()
+	buf := make([]byte, 1024)
+	for {
+		n, addr, err := s.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		msg := buf[:n]
+		if len(msg) < 13 || !bytes.Equal(msg[:5], []byte("RREF\x00")) {
+			continue
+		}
+		freq := int32(binary.LittleEndian.Uint32(msg[5:]))
+		index := int32(binary.LittleEndian.Uint32(msg[9:]))
+		name := string(bytes.TrimRight(msg[13:], "\x00"))
+
+		key := addr.String()
+		s.mu.Lock()
+		if freq == 0 {
+			delete(s.subs[key], index)
+		} else {
+			if s.subs[key] == nil {
+				s.subs[key] = make(map[int32]string)
+				s.addrs[key] = addr
+			}
+			s.subs[key][index] = name
+		}
+		s.mu.Unlock()
+	}
+}
+
+type udpPacket struct {
+	addr *net.UDPAddr
+	data []byte
+}
+
+// sendLoop sends every subscribed value to each client, a packet per 100
+func (s *UDPServer) sendLoop() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(udpSendInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+
+		var packets []udpPacket
+		s.mu.Lock()
+		if !s.silent {
+			for key, subs := range s.subs {
+				data := []byte("RREF,")
+				for index, name := range subs {
+					var entry [8]byte
+					binary.LittleEndian.PutUint32(entry[:], uint32(index))
+					binary.LittleEndian.PutUint32(entry[4:], math.Float32bits(s.values[name]))
+					data = append(data, entry[:]...)
+					if len(data) >= 5+100*8 {
+						packets = append(packets, udpPacket{s.addrs[key], data})
+						data = []byte("RREF,")
+					}
+				}
+				if len(data) > 5 {
+					packets = append(packets, udpPacket{s.addrs[key], data})
+				}
+			}
+		}
+		s.mu.Unlock()
+
+		for _, p := range packets {
+			s.conn.WriteToUDP(p.data, p.addr)
+		}
+	}
+}
+
+// BeaconPacket encodes the BECN multicast beacon X-Plane sends to announce
+// itself. role is 1 for a master, 2 for an external visual and 3 for an IOS.
+func BeaconPacket(hostname string, version, role, udpPort int) []byte {
+	packet := []byte("BECN\x00")
+	packet = append(packet, 1, 2) // beacon version 1.2
+	packet = binary.LittleEndian.AppendUint32(packet, 1)
+	packet = binary.LittleEndian.AppendUint32(packet, uint32(version))
+	packet = binary.LittleEndian.AppendUint32(packet, uint32(role))
+	packet = binary.LittleEndian.AppendUint16(packet, uint16(udpPort))
+	packet = append(packet, hostname...)
+	packet = append(packet, 0)
+	return binary.LittleEndian.AppendUint16(packet, 0) // RakNet port, unused
+}
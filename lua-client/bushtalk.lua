@@ -112,6 +112,12 @@ local dr_groundspeed = dataref_table("sim/flightmodel/position/groundspeed")
 local dr_heading = dataref_table("sim/flightmodel/position/mag_psi")
 local dr_tailnum = dataref_table("sim/aircraft/view/acf_tailnum")
 
+-- X-Plane's own on-ground flag; AGL alone is wrong for floats and tall gear
+local dr_onground = nil
+if XPLMFindDataRef("sim/flightmodel/failures/onground_any") ~= nil then
+    dr_onground = dataref_table("sim/flightmodel/failures/onground_any")
+end
+
 -- State
 local state = {
     logged_in = config.token ~= "",
@@ -220,6 +226,11 @@ local function send_position()
     local alt_meters = dr_altitude_agl[0]
     local speed_ms = dr_groundspeed[0]
 
+    local on_ground = alt_meters < 1.0  -- fallback: below 1 meter AGL
+    if dr_onground ~= nil then
+        on_ground = dr_onground[0] ~= 0
+    end
+
     local payload = {
         PLANE_LATITUDE = lat,
         PLANE_LONGITUDE = lon,
@@ -227,7 +238,7 @@ local function send_position()
         GROUND_VELOCITY = speed_ms * 1.94384,          -- m/s to knots
         MAGNETIC_COMPASS = dr_heading[0],
         ATC_ID = get_tail_number(),
-        SIM_ON_GROUND = alt_meters < 1.0,
+        SIM_ON_GROUND = on_ground,
     }
 
     state.last_position = payload